@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"time"
+)
+
+// 设置是否记录每个数据项最后一次被Get读取的时间，默认关闭
+// 开启后每次Get都需要额外加锁写入读取时间，会带来一定开销
+// 开启后EvictOldest会优先淘汰最久未被读取的数据项
+func (c *Cache) SetTrackAccess(enabled bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.trackAccess = enabled
+	c.accessMu.Lock()
+	if enabled {
+		if c.accessed == nil {
+			c.accessed = map[string]int64{}
+		}
+	} else {
+		c.accessed = nil
+	}
+	c.accessMu.Unlock()
+}
+
+// 返回数据项最后一次被Get读取的时间，未开启记录或数据项未被读取过时返回false
+func (c *Cache) LastAccessed(k string) (time.Time, bool) {
+	c.accessMu.Lock()
+	defer c.accessMu.Unlock()
+	t, found := c.accessed[k]
+	if !found {
+		return time.Time{}, false
+	}
+	return time.Unix(0, t), true
+}
+
+// 记录数据项的读取时间，调用方需要持有c.mu的读锁或写锁
+func (c *Cache) touchAccess(k string) {
+	now := c.now()
+	c.accessMu.Lock()
+	c.accessed[k] = now
+	c.accessMu.Unlock()
+}
+
+// 返回数据项最后一次被读取的时间，未被读取过时返回0，调用方需要持有c.mu的锁
+func (c *Cache) accessTime(k string) int64 {
+	c.accessMu.Lock()
+	defer c.accessMu.Unlock()
+	return c.accessed[k]
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastAccessed(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("k", "v", DefaultExpiration)
+	c.Get("k")
+	if _, found := c.LastAccessed("k"); found {
+		t.Fatal("LastAccessed recorded a read with tracking off")
+	}
+
+	c.SetTrackAccess(true)
+	if _, found := c.LastAccessed("k"); found {
+		t.Fatal("LastAccessed found a key that was not read after enabling tracking")
+	}
+	before := time.Now()
+	c.Get("k")
+	at, found := c.LastAccessed("k")
+	if !found || at.Before(before) || at.After(time.Now()) {
+		t.Fatalf("LastAccessed() = %v, %v, want a time after %v", at, found, before)
+	}
+	c.Delete("k")
+	if _, found := c.LastAccessed("k"); found {
+		t.Fatal("LastAccessed kept the read time of a deleted key")
+	}
+}
+
+func TestLastAccessedUsesCacheClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c, _ := newFakeClockCache(start)
+	c.SetTrackAccess(true)
+	c.Set("k", "v", DefaultExpiration)
+	c.Get("k")
+	if at, _ := c.LastAccessed("k"); !at.Equal(start) {
+		t.Fatalf("LastAccessed() = %v, want the cache clock %v", at, start)
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// 开启合并写入的自动保存：数据项变化后缓存被标记为已修改，
+// 后台协程每隔maxInterval检查一次，只有在被修改过时才调用SaveToFile保存到file，
+// 因此短时间内的多次修改只会触发一次保存。StopGC时如果仍有未保存的修改会最后保存一次，
+// StopGC会等待这次保存完成后才返回；maxInterval必须为正数，否则返回错误
+func (c *Cache) EnableDebouncedSave(file string, maxInterval time.Duration) error {
+	if maxInterval <= 0 {
+		return fmt.Errorf("Save interval must be positive, got %v", maxInterval)
+	}
+	c.saveWG.Add(1)
+	go func() {
+		defer c.saveWG.Done()
+		ticker := time.NewTicker(maxInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.saveIfDirty(file)
+			case <-c.done:
+				c.saveIfDirty(file)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// 缓存被修改过时保存到文件，保存失败时保留修改标记以便下次重试
+func (c *Cache) saveIfDirty(file string) {
+	if !atomic.CompareAndSwapInt32(&c.dirty, 1, 0) {
+		return
+	}
+	if err := c.SaveToFile(file); err != nil {
+		atomic.StoreInt32(&c.dirty, 1)
+	}
+}
+
+// 标记缓存已被修改
+func (c *Cache) markDirty() {
+	atomic.StoreInt32(&c.dirty, 1)
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 统计Encode调用次数的gob编码
+type countingCodec struct {
+	gobCodec
+	encodes int32
+}
+
+func (cc *countingCodec) Encode(w io.Writer, items map[string]Item) error {
+	atomic.AddInt32(&cc.encodes, 1)
+	return cc.gobCodec.Encode(w, items)
+}
+
+func TestDebouncedSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.gob")
+	c := NewCache(NoExpiration, time.Hour)
+	codec := &countingCodec{}
+	c.SetCodec(codec)
+	if err := c.EnableDebouncedSave(file, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		c.Set("n", i, NoExpiration)
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	elapsed := time.Since(start)
+	c.StopGC()
+	// 每个周期最多保存一次，再加上StopGC时的最后一次
+	limit := int32(elapsed/(20*time.Millisecond)) + 2
+	if n := atomic.LoadInt32(&codec.encodes); n == 0 || n > limit {
+		t.Fatalf("saved %d times in %v, want between 1 and %d", n, elapsed, limit)
+	}
+
+	d := NewCache(NoExpiration, time.Hour)
+	if err := d.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.Get("n"); v != 999 {
+		t.Fatalf("saved n = %v, want 999: the final save did not finish before StopGC returned", v)
+	}
+}
+
+func TestDebouncedSaveRejectsBadInterval(t *testing.T) {
+	c := NewCache(NoExpiration, time.Hour)
+	defer c.StopGC()
+	if err := c.EnableDebouncedSave("unused", 0); err == nil {
+		t.Fatal("EnableDebouncedSave accepted a zero interval")
+	}
+}
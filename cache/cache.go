@@ -1,22 +1,48 @@
 package cache
 
 import (
+	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// 写入数据项时过期时间d的解析顺序：
+//  1. d为DefaultExpiration时，使用缓存的默认过期时间c.DefaultExpiration
+//  2. 解析后的d不是正数(NoExpiration、其他负数，或缓存默认值本身为0)时，数据项永不过期
+//  3. 设置了SetMaxTTL时，过期时间被截断为不超过最长存活时间
+//
+// NewCache会把为0的默认过期时间规范为NoExpiration，因此"使用默认值"不会再指向另一个"使用默认值"
 const (
 	NoExpiration      time.Duration = -1 // 没有过期的时间标志, 代表数据项永远不过期
 	DefaultExpiration time.Duration = 0  // 默认的过期时间, 标记数据项应该拥有一个默认过期时间
 )
 
+var (
+	ErrKeyExists    = errors.New("Item already exists")           // Add的数据项已经存在
+	ErrKeyNotFound  = errors.New("Item doesn't exist")            // 数据项不存在或已过期
+	ErrWriteTooSoon = errors.New("Item was written too recently") // 距离上一次写入的时间小于SetMinWriteInterval设置的间隔
+)
+
 type Item struct {
-	Object     interface{} // 存储任意类型的对象
-	Expiration int64       // 数据项过期时间，Unix时间戳，单位是纳秒
+	Object     interface{}       // 存储任意类型的对象
+	Expiration int64             // 数据项过期时间，Unix时间戳，单位是纳秒
+	Size       int64             // 数据项占用的字节数，由SetWithSize设置，默认为0
+	Written    int64             // 数据项的值最后一次被写入的时间，续期不会改变它，Unix时间戳，单位是纳秒，加载时保留保存时的值
+	Priority   int               // 淘汰优先级，由SetWithPriority设置，值越小越先被EvictOldest淘汰，默认为0
+	FirstSet   int64             // 数据项第一次被SetRenewable写入的时间，Unix时间戳，单位是纳秒，0表示未使用
+	Deadline   int64             // SetRenewable设置的最晚过期时间，GetRefresh等续期不会超过它，Unix时间戳，单位是纳秒，0表示不限制
+	Meta       map[string]string // 数据项的元数据，由SetWithMeta设置，随Save保存
 }
 
 // 判断数据项是否已经过期
@@ -27,12 +53,92 @@ func (item Item) Expired() bool {
 	return time.Now().UnixNano() > item.Expiration
 }
 
+// 将续期得到的过期时间exp截断为不晚于数据项的最晚过期时间，0表示永不过期
+func (item Item) capExpiration(exp int64) int64 {
+	if item.Deadline > 0 && (exp == 0 || exp > item.Deadline) {
+		return item.Deadline
+	}
+	return exp
+}
+
 type Cache struct {
 	DefaultExpiration time.Duration
-	items             map[string]Item // 缓存数据项存在map中
-	mu                sync.RWMutex    // 读写锁
-	gcInterval        time.Duration   // 过期数据项清理周期
+	items             store         // 缓存数据项，默认存在map中
+	mu                sync.RWMutex  // 读写锁
+	newStore          func() store  // 创建数据项存储，nil表示使用mapStore
+	cowSave           bool          // Save是否使用写时复制
+	cowRefs           int           // 正在保存中且引用了当前map的Save数量
+	cowEpoch          uint64        // 每次复制或替换map时加一，用于判断Save引用的是否仍是当前map
+	gcInterval        time.Duration // 过期数据项清理周期
+	gcMin             time.Duration // 自适应清理周期的下限
+	gcMax             time.Duration // 自适应清理周期的上限，0表示不开启
+	gcMaxDrift        time.Duration // 两次清理之间允许的最大时间偏差，0表示清理周期的两倍
+	curGCInterval     int64         // 当前的清理周期，原子操作
 	stopGC            chan bool
+	done              chan struct{} // 关闭时通知时钟刷新、WatchFile等后台协程退出
+	doneOnce          sync.Once
+	saveWG            sync.WaitGroup                                   // 自动保存协程，StopGC等待其完成最后一次保存
+	loadMu            sync.Mutex                                       // 保护calls和loadSem
+	calls             map[string]*call                                 // 正在进行中的加载
+	loadSem           chan struct{}                                    // 限制并发加载数量的信号量
+	loader            func(string) (interface{}, time.Duration, error) // GetLoad使用的默认加载函数
+	cbMu              sync.Mutex                                       // 保护expireCallbacks，Get只持有读锁，因此需要单独的锁
+	expireCallbacks   map[string]func(string, interface{})             // 单个数据项的过期回调
+	onEvicted         func(string, interface{})                        // 数据项被删除时的回调
+	highWater         int                                              // 高水位阈值
+	onHighWater       func(int)                                        // 超过高水位时的回调
+	highWaterFired    bool                                             // 本次超过高水位后是否已经调用过回调
+	trackAccess       bool                                             // 是否记录数据项的最后读取时间
+	accessMu          sync.Mutex                                       // 保护accessed，Get只持有读锁，因此需要单独的锁
+	accessed          map[string]int64                                 // 数据项最后一次被Get读取的时间，Unix时间戳，单位是纳秒
+	coarseClock       int32                                            // 是否使用定时刷新的粗粒度时间，原子操作
+	coarseNow         int64                                            // 粗粒度的当前时间，Unix时间戳，单位是纳秒，原子操作
+	stopClock         chan struct{}                                    // 停止刷新粗粒度时间
+	numericCoercion   bool                                             // 整数加减时是否解析字符串形式的数字
+	evictionSample    int                                              // 近似LRU淘汰的采样数量，0表示检查全部数据项
+	noLazyExpiration  bool                                             // 为true时读取不检查过期，默认检查
+	minWriteInterval  time.Duration                                    // 覆盖写入的最小间隔，0表示不限制
+	maxTTL            time.Duration                                    // 数据项的最长存活时间，0表示不限制
+	onEvictedBatch    func([]EvictedItem)                              // 批量删除回调，每次DeleteExpired调用一次
+	hotMu             sync.Mutex                                       // 保护hotCounts和hotStart，Get只持有读锁，需要单独的锁
+	hotCounts         map[string]int64                                 // 热点key的读取次数
+	hotLimit          int                                              // 最多统计的key数量，0表示不开启热点key统计
+	hotWindow         time.Duration                                    // 热点key统计的时间窗口，0表示不按时间清空
+	hotStart          int64                                            // 当前统计窗口的开始时间，Unix时间戳，单位是纳秒
+	deadLetter        *Cache                                           // 死信缓存，过期数据项被清理时写入它
+	deadLetterTTL     time.Duration                                    // 写入死信缓存的数据项的过期时间
+	deadLetterSink    int                                              // 把该缓存作为死信缓存的其他缓存数量
+	gracePeriod       time.Duration                                    // 过期数据项的宽限期
+	asyncEvicted      bool                                             // 批量删除时是否并行执行OnEvicted回调
+	negative          map[string]int64                                 // GetOrSetNeg记录的不存在的key及其过期时间
+	debugChecks       bool                                             // 是否在每次修改后检查内部数据结构的一致性
+	copyOnSet         bool                                             // 写入值时是否写入值的深拷贝
+	rejectEmptyKeys   bool                                             // 是否拒绝空字符串key
+	maxKeyLen         int                                              // key的最大长度，0表示不限制
+	wal               *wal                                             // 预写日志，nil表示未开启
+	noGobRegister     bool                                             // 为true时gob保存前不自动注册值的类型
+	indexes           map[string]*index                                // 二级索引
+	codec             Codec                                            // Save和Load使用的序列化方式，nil表示gob
+	gcRunning         int32                                            // gcLoop协程是否存活，原子操作
+	lastGC            int64                                            // 最后一次GC的时间，Unix时间戳，单位是纳秒，原子操作
+	dirty             int32                                            // 上次自动保存之后是否被修改过，原子操作
+	generation        uint64                                           // 整体替换数据项的次数，原子操作
+	sweeping          int32                                            // 正在执行的DeleteExpired数量，原子操作
+	lastGCDuration    int64                                            // 最近一次清理持有写锁的时间，单位是纳秒，原子操作
+	maxGCDuration     int64                                            // 清理持有写锁时间的最大值，单位是纳秒，原子操作
+	slowGCLog         *log.Logger                                      // 慢清理日志，nil表示不记录
+	slowGCThreshold   time.Duration                                    // 清理持有写锁的时间超过该值时记录日志
+	lastGCRemoved     int64                                            // 最后一次GC清理的数据项数量，原子操作
+	clock             func() int64                                     // 返回当前时间，Unix时间戳，单位是纳秒，nil表示使用time.Now，用于测试
+}
+
+// 缓存的运行状态
+type CacheStatus struct {
+	ItemCount     int           // 数据项数量
+	GCRunning     bool          // 过期数据项清理协程是否在运行
+	LastGCTime    time.Time     // 最后一次清理的时间，从未清理过时为零值
+	LastGCRemoved int           // 最后一次清理删除的数据项数量
+	GCInterval    time.Duration // 当前的清理周期，开启自适应清理周期时会动态变化
 }
 
 // 过期缓存数据项清理
@@ -50,11 +156,16 @@ type Cache struct {
 我们就停止 gcLoop() 的运行。
 */
 func (c *Cache) gcLoop() {
-	ticker := time.NewTicker(c.gcInterval) //
+	defer atomic.StoreInt32(&c.gcRunning, 0)
+	st := &gcState{interval: c.gcInterval, last: c.now()}
+	atomic.StoreInt64(&c.curGCInterval, int64(st.interval))
+	ticker := time.NewTicker(st.interval) //
 	for {
 		select {
 		case <-ticker.C:
-			c.DeleteExpired() // 通过time.Ticker定期执行DeleteExpired()方法，清理过期的数据项
+			if _, reset := c.gcTick(st); reset {
+				ticker.Reset(st.interval) // 从现在开始按新的清理周期重新计时
+			}
 		case <-c.stopGC:
 			ticker.Stop()
 			return
@@ -62,63 +173,655 @@ func (c *Cache) gcLoop() {
 	}
 }
 
-// 删除缓存数据项
-func (c *Cache) delete(k string) {
-	delete(c.items, k)
+// gcLoop在两次tick之间保存的状态
+type gcState struct {
+	interval time.Duration // 当前的清理周期
+	last     int64         // 上一次清理的时间，Unix时间戳，单位是纳秒
+	churn    float64       // 近期平均每次清理的数据项数量
+}
+
+// 处理一次tick，返回是否执行了清理以及是否需要重置ticker
+// 距离上一次清理不足半个周期的tick(例如休眠唤醒后紧接着到达的tick)会被跳过，每次唤醒最多清理一次；
+// 两次清理之间的时间间隔超过允许的最大偏差时(时间跳跃)，需要从现在重新开始计时
+func (c *Cache) gcTick(st *gcState) (ran, reset bool) {
+	now := c.now()
+	if now-st.last < int64(st.interval/2) {
+		return false, false
+	}
+	reset = now-st.last > int64(c.gcDriftLimit(st.interval))
+	st.last = now
+	n := c.deleteExpired() // 通过time.Ticker定期执行DeleteExpired()方法，清理过期的数据项
+	c.recordGC(n)
+	st.churn = (st.churn*3 + float64(n)) / 4
+	if next := c.adaptGCInterval(st.interval, n, st.churn); next != st.interval {
+		st.interval = next
+		atomic.StoreInt64(&c.curGCInterval, int64(next))
+		reset = true
+	}
+	return true, reset
+}
+
+// 记录一次定期清理的时间和删除的数量，供Status返回
+func (c *Cache) recordGC(n int) {
+	atomic.StoreInt64(&c.lastGCRemoved, int64(n))
+	atomic.StoreInt64(&c.lastGC, c.now())
+}
+
+// 设置清理周期允许的最大时间偏差，两次tick之间的墙上时间间隔超过该值时重置清理计时
+// d <= 0 表示使用默认值，即清理周期的两倍
+func (c *Cache) SetGCMaxDrift(d time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.gcMaxDrift = d
+}
+
+// 返回当前清理周期下允许的最大时间偏差
+func (c *Cache) gcDriftLimit(interval time.Duration) time.Duration {
+	c.mu.RLock()
+	d := c.gcMaxDrift
+	c.mu.RUnlock()
+	if d <= 0 {
+		d = 2 * interval
+	}
+	return d
+}
+
+// 开启自适应清理周期，清理周期会在[min, max]范围内根据过期数据项的数量自动调整
+// 清理出的数据项不少于近期平均值时周期减半，近期几乎没有过期数据项时周期加倍
+// max <= 0 表示关闭，恢复为固定的清理周期；开启时min必须大于0且不大于max，否则返回错误且不修改设置
+func (c *Cache) SetAdaptiveGC(min, max time.Duration) error {
+	if max > 0 && (min <= 0 || min > max) {
+		return fmt.Errorf("Invalid adaptive GC range [%v, %v]", min, max)
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	c.gcMin, c.gcMax = min, max
+	return nil
+}
+
+// 根据本次清理数量n和近期平均清理数量churn计算下一个清理周期
+func (c *Cache) adaptGCInterval(interval time.Duration, n int, churn float64) time.Duration {
+	c.mu.RLock()
+	min, max := c.gcMin, c.gcMax
+	c.mu.RUnlock()
+	if max <= 0 {
+		return c.gcInterval
+	}
+	switch {
+	case churn < 1:
+		interval *= 2
+	case float64(n) >= churn:
+		interval /= 2
+	}
+	if interval < min {
+		interval = min
+	}
+	if interval > max {
+		interval = max
+	}
+	if interval <= 0 {
+		interval = c.gcInterval
+	}
+	return interval
+}
+
+// 删除缓存数据项，如果设置了OnEvicted回调，返回被删除的值以便在锁外调用回调
+func (c *Cache) delete(k string) (interface{}, bool) {
+	v, found := c.items.get(k)
+	if !found {
+		return nil, false
+	}
+	c.beforeWrite()
+	c.items.delete(k)
+	c.logWAL(walDelete, k, Item{})
+	c.clearExpireCallback(k)
+	c.markDirty()
+	c.indexDelete(k)
+	if c.accessed != nil {
+		c.accessMu.Lock()
+		delete(c.accessed, k)
+		c.accessMu.Unlock()
+	}
+	if c.debugChecks {
+		c.checkInvariants()
+	}
+	if c.onEvicted != nil {
+		return v.Object, true
+	}
+	return nil, false
+}
+
+// 被删除的数据项，用于在锁外调用OnEvicted回调
+type keyAndValue struct {
+	key   string
+	value interface{}
+}
+
+// 设置数据项被删除时(包括过期清理)调用的回调函数，传入nil表示取消
+// 回调在锁外执行
+func (c *Cache) OnEvicted(f func(string, interface{})) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.onEvicted = f
+}
+
+// 一起被删除的数据项，用于OnEvictedBatch回调
+type EvictedItem struct {
+	Key   string
+	Value interface{}
+}
+
+// 设置批量删除回调，DeleteExpired每次清理出的全部过期数据项会在一次回调中传入，传入nil表示取消
+// 没有清理出数据项时不调用；回调在锁外执行，与OnEvicted回调互不影响
+func (c *Cache) OnEvictedBatch(f func(evicted []EvictedItem)) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.onEvictedBatch = f
+}
+
+// 设置一次删除多个数据项(如DeleteExpired)时回调的执行方式，默认为同步
+// 同步模式下按key的字典序依次执行回调，全部执行完之后DeleteExpired等方法才返回；
+// 异步模式下每个回调在单独的协程中并行执行，不保证顺序。两种模式下回调都不持有锁
+func (c *Cache) SetEvictionCallbackMode(sync bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.asyncEvicted = !sync
+}
+
+// 返回在锁外对被删除的数据项调用OnEvicted回调的函数，调用方需要持有锁
+func (c *Cache) evictedNotifier(items []keyAndValue) func() {
+	onEvicted := c.onEvicted
+	if onEvicted == nil || len(items) == 0 {
+		return func() {}
+	}
+	if c.asyncEvicted {
+		return func() {
+			for _, v := range items {
+				go onEvicted(v.key, v.value)
+			}
+		}
+	}
+	return func() {
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].key < items[j].key
+		})
+		for _, v := range items {
+			onEvicted(v.key, v.value)
+		}
+	}
 }
 
 // 删除过期数据项
 func (c *Cache) DeleteExpired() {
-	now := time.Now().UnixNano()
+	c.deleteExpired()
+}
+
+// 返回当前是否有DeleteExpired正在执行(包括执行其后的回调)，与CacheStatus.GCRunning表示的清理协程是否存活不同
+func (c *Cache) IsGCRunning() bool {
+	return atomic.LoadInt32(&c.sweeping) > 0
+}
+
+// 返回最近一次DeleteExpired持有写锁的时间，还没有执行过时返回0
+func (c *Cache) LastGCDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.lastGCDuration))
+}
+
+// 返回所有DeleteExpired中持有写锁时间的最大值
+func (c *Cache) MaxGCDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.maxGCDuration))
+}
+
+// 设置慢清理日志，DeleteExpired持有写锁的时间超过threshold时写入l，l为nil表示关闭
+func (c *Cache) SetSlowGCLog(l *log.Logger, threshold time.Duration) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	c.slowGCLog, c.slowGCThreshold = l, threshold
+}
 
-	for k, v := range c.items {
-		if v.Expiration > 0 && now > v.Expiration {
-			c.delete(k)
+// 记录一次清理的耗时并更新最大值，返回d
+func (c *Cache) recordGCDuration(d time.Duration) time.Duration {
+	atomic.StoreInt64(&c.lastGCDuration, int64(d))
+	for {
+		max := atomic.LoadInt64(&c.maxGCDuration)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&c.maxGCDuration, max, int64(d)) {
+			return d
 		}
 	}
 }
 
-// 设置缓存数据项，如果数据项存在则覆盖
-func (c *Cache) Set(k string, v interface{}, d time.Duration) {
-	var e int64
-	if d == DefaultExpiration {
-		d = c.DefaultExpiration
+// 删除过期数据项，返回删除的数量
+func (c *Cache) deleteExpired() int {
+	atomic.AddInt32(&c.sweeping, 1)
+	defer atomic.AddInt32(&c.sweeping, -1)
+	var evictedItems []keyAndValue
+	var expireCallbacks []func()
+	now := c.now()
+	c.mu.Lock()
+	start := time.Now()
+	grace := int64(c.gracePeriod)
+	slowLog, slowThreshold := c.slowGCLog, c.slowGCThreshold
+	dl, dlTTL := c.deadLetterTarget(), c.deadLetterTTL
+	var deadLetters []keyAndValue
+	onBatch := c.onEvictedBatch
+	var batch []EvictedItem
+	n := 0
+	c.items.rangeItems(func(k string, v Item) bool {
+		if v.Expiration > 0 && now > v.Expiration+grace {
+			if dl != nil {
+				deadLetters = append(deadLetters, keyAndValue{k, v.Object})
+			}
+			if onBatch != nil {
+				batch = append(batch, EvictedItem{k, v.Object})
+			}
+			if onExpire := c.takeExpireCallback(k); onExpire != nil {
+				k, v := k, v.Object
+				expireCallbacks = append(expireCallbacks, func() { onExpire(k, v) })
+			}
+			ov, evicted := c.delete(k)
+			if evicted {
+				evictedItems = append(evictedItems, keyAndValue{k, ov})
+			}
+			n++
+		}
+		return true
+	})
+	for k, exp := range c.negative {
+		if now > exp {
+			delete(c.negative, k)
+		}
 	}
-	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
+	notify := c.evictedNotifier(evictedItems)
+	c.unlock()
+	elapsed := c.recordGCDuration(time.Since(start))
+	if slowLog != nil && elapsed > slowThreshold {
+		slowLog.Printf("cache: DeleteExpired held the lock for %v, removed %d items", elapsed, n)
+	}
+	if dl != nil {
+		dl.putDeadLetters(deadLetters, dlTTL)
+	}
+	for _, f := range expireCallbacks {
+		f()
+	}
+	notify()
+	if len(batch) > 0 {
+		onBatch(batch)
 	}
+	return n
+}
+
+// 设置缓存数据项，如果数据项存在则覆盖，key不合法时返回错误
+// v可以为nil，存储的nil值和其他值一样是一个有效的数据项
+func (c *Cache) Set(k string, v interface{}, d time.Duration) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.items[k] = Item{
+	defer c.unlock()
+	return c.checkedSet(k, v, d)
+}
+
+// 按Set的规则检查key、复制值并检查写入间隔，全部通过后写入数据项，调用方需要持有锁
+func (c *Cache) checkedSet(k string, v interface{}, d time.Duration) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		return err
+	}
+	if err := c.checkWriteInterval(k); err != nil {
+		return err
+	}
+	c.set(k, v, d)
+	return nil
+}
+
+// 设置已经序列化好的字节数据，例如缓存的HTTP响应体，等价于以[]byte调用Set
+// 保存时与其他数据项一样使用当前的序列化方式；[]byte是gob预先注册的基本类型，关闭自动注册时也能正常保存
+func (c *Cache) SetRaw(k string, b []byte, d time.Duration) error {
+	return c.Set(k, b, d)
+}
+
+// 获取通过SetRaw设置的字节数据，数据项不存在、已过期或不是[]byte时返回false
+func (c *Cache) GetRaw(k string) ([]byte, bool) {
+	v, found := c.Get(k)
+	if !found {
+		return nil, false
+	}
+	b, ok := v.([]byte)
+	return b, ok
+}
+
+// 设置缓存数据项并记录其字节数，用于TotalSize和ItemSize统计
+func (c *Cache) SetWithSize(k string, v interface{}, d time.Duration, size int64) error {
+	c.mu.Lock()
+	if err := c.checkKey(k); err != nil {
+		c.unlock()
+		return err
+	}
+	if err := c.checkWriteInterval(k); err != nil {
+		c.unlock()
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		c.unlock()
+		return err
+	}
+	c.setItem(k, Item{
 		Object:     v,
-		Expiration: e,
+		Expiration: c.expiration(d),
+		Size:       size,
+	})
+	c.unlock()
+	return nil
+}
+
+// 设置带淘汰优先级的缓存数据项，EvictOldest优先淘汰priority较小的数据项
+// 之后用Set等方法覆盖写入时优先级会恢复为默认的0
+func (c *Cache) SetWithPriority(k string, v interface{}, d time.Duration, priority int) error {
+	c.mu.Lock()
+	if err := c.checkKey(k); err != nil {
+		c.unlock()
+		return err
 	}
+	if err := c.checkWriteInterval(k); err != nil {
+		c.unlock()
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		c.unlock()
+		return err
+	}
+	c.setItem(k, Item{
+		Object:     v,
+		Expiration: c.expiration(d),
+		Priority:   priority,
+	})
+	c.unlock()
+	return nil
+}
+
+// 设置带元数据(如来源、版本、标签)的缓存数据项，元数据会被复制一份保存，并随Save/Load保存和恢复
+// 之后用Set等方法覆盖写入时元数据会被清除
+func (c *Cache) SetWithMeta(k string, v interface{}, d time.Duration, meta map[string]string) error {
+	c.mu.Lock()
+	if err := c.checkKey(k); err != nil {
+		c.unlock()
+		return err
+	}
+	if err := c.checkWriteInterval(k); err != nil {
+		c.unlock()
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		c.unlock()
+		return err
+	}
+	c.setItem(k, Item{
+		Object:     v,
+		Expiration: c.expiration(d),
+		Meta:       copyMeta(meta),
+	})
+	c.unlock()
+	return nil
+}
+
+// 返回数据项元数据的副本，数据项不存在或已过期时返回false，没有元数据时返回nil和true
+func (c *Cache) GetMeta(k string) (map[string]string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
+		return nil, false
+	}
+	return copyMeta(item.Meta), true
+}
+
+// 复制一份元数据，nil仍返回nil
+func copyMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(meta))
+	for k, v := range meta {
+		cp[k] = v
+	}
+	return cp
+}
+
+// 设置可续期的缓存数据项，每次写入把过期时间续期为renewTTL之后，
+// 但不会晚于数据项第一次写入之后的maxLifetime，即过期时间为min(now+renewTTL, firstSet+maxLifetime)
+// 数据项不存在、已过期或上一次不是由SetRenewable写入时，重新开始计算存活时间；maxLifetime <= 0 表示不限制
+// 之后通过GetRefresh、TouchFunc续期同样不会超过firstSet+maxLifetime
+func (c *Cache) SetRenewable(k string, v interface{}, renewTTL, maxLifetime time.Duration) error {
+	c.mu.Lock()
+	if err := c.checkKey(k); err != nil {
+		c.unlock()
+		return err
+	}
+	if err := c.checkWriteInterval(k); err != nil {
+		c.unlock()
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		c.unlock()
+		return err
+	}
+	now := c.now()
+	first := now
+	if old, found := c.items.get(k); found && !c.expired(old) && old.FirstSet > 0 {
+		first = old.FirstSet
+	}
+	item := Item{
+		Object:   v,
+		FirstSet: first,
+	}
+	if maxLifetime > 0 {
+		item.Deadline = first + int64(maxLifetime)
+	}
+	item.Expiration = item.capExpiration(c.expiration(renewTTL))
+	c.setItem(k, item)
+	c.unlock()
+	return nil
+}
+
+// 返回所有未过期数据项的字节数之和
+func (c *Cache) TotalSize() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var n int64
+	c.items.rangeItems(func(_ string, v Item) bool {
+		if !c.expired(v) {
+			n += v.Size
+		}
+		return true
+	})
+	return n
+}
+
+// 返回数据项的字节数
+func (c *Cache) ItemSize(k string) (int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
+		return 0, false
+	}
+	return item.Size, true
+}
+
+// 在一次加锁中设置多个数据项，返回新建的key和覆盖了未过期旧值的key，均按字典序排列
+// 不合法的key、距离上一次写入不足SetMinWriteInterval的key以及开启SetCopyOnSet时无法复制的值会被跳过，不出现在任何一个返回值中
+func (c *Cache) SetManyReport(items map[string]interface{}, d time.Duration) (created, overwritten []string) {
+	c.mu.Lock()
+	for k, v := range items {
+		if c.checkKey(k) != nil || c.checkWriteInterval(k) != nil {
+			continue
+		}
+		v, err := c.copyOnSetValue(v)
+		if err != nil {
+			continue
+		}
+		if _, found := c.get(k); found {
+			overwritten = append(overwritten, k)
+		} else {
+			created = append(created, k)
+		}
+		c.set(k, v, d)
+	}
+	c.unlock()
+	sort.Strings(created)
+	sort.Strings(overwritten)
+	return created, overwritten
+}
+
+// 设置key的最大长度，超过长度的key在Set/Add/Replace等写入时会返回错误，0表示不限制
+func (c *Cache) SetMaxKeyLength(n int) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.maxKeyLen = n
+}
+
+// 设置是否拒绝空字符串key，开启后Set/Add/Replace等写入空key会返回错误，默认关闭
+// 用于尽早发现key没有被正确计算出来的问题
+func (c *Cache) SetRejectEmptyKeys(reject bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.rejectEmptyKeys = reject
+}
+
+// 检查key是否合法，调用方需要持有锁
+func (c *Cache) checkKey(k string) error {
+	if c.rejectEmptyKeys && k == "" {
+		return fmt.Errorf("Empty key is not allowed")
+	}
+	if c.maxKeyLen > 0 && len(k) > c.maxKeyLen {
+		return fmt.Errorf("Key length %d exceeds the limit %d", len(k), c.maxKeyLen)
+	}
+	return nil
+}
+
+// 设置覆盖写入的最小间隔，距离上一次写入值(Item.Written)不足d的Set、Replace、GetSet、SetWith*等覆盖写入会被跳过，
+// 返回错误的方法返回ErrWriteTooSoon；GetRefresh、TouchFunc等续期不算写入，不受限制也不更新写入时间，d <= 0 表示不限制
+func (c *Cache) SetMinWriteInterval(d time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.minWriteInterval = d
+}
+
+// 检查是否允许覆盖写入数据项，调用方需要持有锁
+func (c *Cache) checkWriteInterval(k string) error {
+	if c.minWriteInterval <= 0 {
+		return nil
+	}
+	item, found := c.items.get(k)
+	if found && !c.expired(item) && c.now()-item.Written < int64(c.minWriteInterval) {
+		return fmt.Errorf("%w: %s", ErrWriteTooSoon, k)
+	}
+	return nil
 }
 
 // 设置数据项，没有锁操作
 func (c *Cache) set(k string, v interface{}, d time.Duration) {
-	var e int64
+	c.setItem(k, Item{
+		Object:     v,
+		Expiration: c.expiration(d),
+	})
+}
+
+// 写入数据项的新值并将写入时间更新为当前时间，同时取消旧值的过期回调，所有单个数据项的修改都通过这里完成，没有锁操作
+func (c *Cache) setItem(k string, item Item) {
+	c.clearExpireCallback(k)
+	item.Written = c.now()
+	c.putItem(k, item)
+}
+
+// 原样写入数据项，保留其中的写入时间，用于续期、加载和重放，没有锁操作
+func (c *Cache) putItem(k string, item Item) {
+	c.beforeWrite()
+	c.items.set(k, item)
+	c.logWAL(walSet, k, item)
+	c.markDirty()
+	c.indexSet(k, item.Object)
+	if c.negative != nil {
+		delete(c.negative, k)
+	}
+	if c.debugChecks {
+		c.checkInvariants()
+	}
+}
+
+// 用items替换全部数据项，没有锁操作
+func (c *Cache) replaceItems(items map[string]Item) {
+	old := c.items
+	c.items = c.storeFrom(items)
+	c.resetCopyOnWrite()
+	atomic.AddUint64(&c.generation, 1)
+	if c.expireCallbacks != nil {
+		c.cbMu.Lock()
+		c.expireCallbacks = map[string]func(string, interface{}){}
+		c.cbMu.Unlock()
+	}
+	c.markDirty()
+	c.rebuildIndexes()
+	if c.accessed != nil {
+		c.accessMu.Lock()
+		c.accessed = map[string]int64{}
+		c.accessMu.Unlock()
+	}
+	if c.wal != nil {
+		c.wal.replace(old, items)
+	}
+	c.negative = nil
+	if c.debugChecks {
+		c.checkInvariants()
+	}
+}
+
+// 设置数据项的最长存活时间，所有写入的过期时间(包括永不过期)都会被截断为不超过d
+// Load、Restore、LoadFromChannel和ReplayWAL恢复的数据项同样会被截断，d <= 0 表示不限制
+func (c *Cache) SetMaxTTL(d time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.maxTTL = d
+}
+
+// 根据过期时间d计算数据项的过期时间戳，0表示永不过期，解析顺序见NoExpiration的说明
+func (c *Cache) expiration(d time.Duration) int64 {
 	if d == DefaultExpiration {
 		d = c.DefaultExpiration
 	}
+	if d <= 0 {
+		d = NoExpiration
+	}
+	if c.maxTTL > 0 && (d == NoExpiration || d > c.maxTTL) {
+		d = c.maxTTL
+	}
 	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
+		return c.now() + int64(d)
 	}
-	c.items[k] = Item{
-		Object:     v,
-		Expiration: e,
+	return 0
+}
+
+// 将加载或重放得到的过期时间戳截断为不超过现在加上最长存活时间，0表示永不过期，调用方需要持有锁
+func (c *Cache) clampExpiration(exp int64) int64 {
+	if c.maxTTL <= 0 {
+		return exp
+	}
+	if limit := c.now() + int64(c.maxTTL); exp == 0 || exp > limit {
+		return limit
 	}
+	return exp
 }
 
 // 获取数据项，如果找到数据项，还需要判断数据项是否已经过期
 func (c *Cache) get(k string) (interface{}, bool) {
-	item, found := c.items[k]
+	item, found := c.items.get(k)
 	if !found {
 		return nil, false
 	}
-	if item.Expired() {
+	if !c.noLazyExpiration && c.expired(item) {
 		return nil, false
 	}
 	return item.Object, true
@@ -127,66 +830,541 @@ func (c *Cache) get(k string) (interface{}, bool) {
 // 添加数据项，如果数据已经存在，则返回错误
 func (c *Cache) Add(k string, v interface{}, d time.Duration) error {
 	c.mu.Lock()
+	if err := c.checkKey(k); err != nil {
+		c.unlock()
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		c.unlock()
+		return err
+	}
 	_, found := c.get(k)
 	if found {
-		c.mu.Unlock()
-		return fmt.Errorf("Item %s already exists", k)
+		c.unlock()
+		return fmt.Errorf("%w: %s", ErrKeyExists, k)
 	}
 	c.set(k, v, d)
-	c.mu.Unlock()
+	c.unlock()
 	return nil
 }
 
-// 获取数据项
+// 设置高水位回调，任意写操作之后数据项数量首次超过threshold时调用f
+// 数量回落到threshold及以下后，下一次超过时会再次调用，回调在锁外执行
+func (c *Cache) OnHighWaterMark(threshold int, f func(count int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.highWater = threshold
+	c.onHighWater = f
+	c.highWaterFired = false
+}
+
+// 释放写锁，并在锁外调用持有锁期间触发的高水位回调，所有写操作都通过它释放写锁
+func (c *Cache) unlock() {
+	notify := c.checkHighWater()
+	c.mu.Unlock()
+	if notify != nil {
+		notify()
+	}
+}
+
+// 检查数据项数量是否超过高水位，返回需要在锁外调用的回调，调用方需要持有锁
+func (c *Cache) checkHighWater() func() {
+	if c.onHighWater == nil {
+		return nil
+	}
+	n := c.items.len()
+	if n <= c.highWater {
+		c.highWaterFired = false
+		return nil
+	}
+	if c.highWaterFired {
+		return nil
+	}
+	c.highWaterFired = true
+	f := c.onHighWater
+	return func() { f(n) }
+}
+
+// 获取数据项，第二个返回值表示数据项是否存在且未过期
+// 通过Set存储的nil值会返回(nil, true)，可以据此区分存储的nil和不存在的数据项
 func (c *Cache) Get(k string) (interface{}, bool) {
+	c.mu.RLock()
+	item, found := c.items.get(k)
+	if !found {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	if !c.noLazyExpiration && c.expired(item) {
+		onExpire := c.takeExpireCallback(k)
+		c.mu.RUnlock()
+		if onExpire != nil {
+			onExpire(k, item.Object)
+		}
+		return nil, false
+	}
+	if c.trackAccess {
+		c.touchAccess(k)
+	}
+	if c.hotLimit > 0 {
+		c.countHotKey(k)
+	}
+	c.mu.RUnlock()
+	return item.Object, true
+}
+
+// 尝试获取数据项，如果无法立即获得读锁(例如有长时间持有写锁的操作)，不等待而直接返回locked=false
+// locked为true时，前两个返回值与Get相同
+func (c *Cache) TryGet(k string) (v interface{}, found bool, locked bool) {
+	if !c.mu.TryRLock() {
+		return nil, false, false
+	}
+	defer c.mu.RUnlock()
+	v, found = c.get(k)
+	return v, found, true
+}
+
+// 获取数据项，切片和map类型的值会返回一份浅拷贝，修改返回值不会影响缓存中的数据
+// 只复制一层：切片或map中的元素如果是指针、切片或map，仍然与缓存共享
+// 其他类型的值直接返回
+func (c *Cache) GetCopy(k string) (interface{}, bool) {
+	v, found := c.Get(k)
+	if !found || v == nil {
+		return v, found
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		if rv.IsNil() {
+			return v, true
+		}
+		cp := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		reflect.Copy(cp, rv)
+		return cp.Interface(), true
+	case reflect.Map:
+		if rv.IsNil() {
+			return v, true
+		}
+		cp := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return cp.Interface(), true
+	}
+	return v, true
+}
+
+// 设置Set、Add、Replace、GetSet、SetWith*等写入值的方法是否写入值的深拷贝，开启后调用方之后修改原始值不会影响缓存中的数据，默认关闭
+// 拷贝通过gob编码再解码完成，未导出的字段不会被复制，无法用gob编码的值会使写入返回错误
+func (c *Cache) SetCopyOnSet(enabled bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.copyOnSet = enabled
+}
+
+// 开启了SetCopyOnSet时返回v的深拷贝，否则直接返回v，调用方需要持有锁
+func (c *Cache) copyOnSetValue(v interface{}) (interface{}, error) {
+	if !c.copyOnSet || v == nil {
+		return v, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("Error copying value of type %T: %v", v, err)
+	}
+	cp := reflect.New(reflect.TypeOf(v))
+	if err := gob.NewDecoder(&buf).DecodeValue(cp); err != nil {
+		return nil, fmt.Errorf("Error copying value of type %T: %v", v, err)
+	}
+	return cp.Elem().Interface(), nil
+}
+
+// 数据项的状态
+type KeyState int
+
+const (
+	Absent  KeyState = iota // 数据项不存在
+	Live                    // 数据项存在且未过期
+	Expired                 // 数据项存在但已过期，尚未被清理
+)
+
+// 获取未过期数据项的完整Item(值、过期时间以及元数据等)，返回的是副本，修改它不会影响缓存
+// 数据项不存在或已过期时返回false
+func (c *Cache) GetItem(k string) (Item, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
+		return Item{}, false
+	}
+	item.Meta = copyMeta(item.Meta)
+	return item, true
+}
+
+// 获取数据项及其状态，可以区分从未设置(或已被清理)和已过期两种未命中
+// 状态为Expired时同时返回过期的旧值
+func (c *Cache) GetDetailed(k string) (value interface{}, state KeyState) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	item, found := c.items[k]
+	item, found := c.items.get(k)
 	if !found {
-		return nil, false
+		return nil, Absent
+	}
+	if c.expired(item) {
+		return item.Object, Expired
+	}
+	return item.Object, Live
+}
+
+// GetOrdered返回的单个查询结果
+type Result struct {
+	Key   string
+	Value interface{}
+	Found bool // 数据项是否存在且未过期
+}
+
+// 在一次加锁中获取多个数据项，结果与keys的顺序一一对应，重复的key会得到重复的结果
+func (c *Cache) GetOrdered(keys []string) []Result {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	results := make([]Result, len(keys))
+	for i, k := range keys {
+		v, found := c.get(k)
+		results[i] = Result{Key: k, Value: v, Found: found}
+	}
+	return results
+}
+
+// 设置读取时是否检查数据项过期，默认开启
+// 关闭后Get会返回已过期但尚未被DeleteExpired删除的数据项，过期数据项只由清理协程删除
+func (c *Cache) SetLazyExpiration(enabled bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.noLazyExpiration = !enabled
+}
+
+// 设置过期数据项的宽限期，过期时间加上宽限期之后才会被DeleteExpired删除
+// 宽限期内的数据项对Get不可见，但可以通过GetStale获取
+func (c *Cache) SetGracePeriod(d time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.gracePeriod = d
+}
+
+// 获取数据项，已过期但仍在宽限期内的数据项也会返回，此时stale为true
+func (c *Cache) GetStale(k string) (v interface{}, stale bool, found bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, found := c.items.get(k)
+	if !found {
+		return nil, false, false
+	}
+	if !c.expired(item) {
+		return item.Object, false, true
+	}
+	if c.now() > item.Expiration+int64(c.gracePeriod) {
+		return nil, false, false
 	}
-	if item.Expired() {
+	return item.Object, true, true
+}
+
+// 获取数据项，如果找到则将其过期时间重置为当前时间加上d，但不会晚于SetRenewable设置的最长存活时间
+func (c *Cache) GetRefresh(k string, d time.Duration) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
 		return nil, false
 	}
+	item.Expiration = item.capExpiration(c.expiration(d))
+	c.putItem(k, item)
 	return item.Object, true
 }
 
+// 在一次加锁中将所有满足pred的未过期数据项的过期时间重置为当前时间加上d，返回处理的数量
+// 与GetRefresh相同，续期不会晚于SetRenewable设置的最长存活时间
+// pred在锁内执行，不能调用缓存的方法
+func (c *Cache) TouchFunc(d time.Duration, pred func(k string, v interface{}) bool) int {
+	c.mu.Lock()
+	defer c.unlock()
+	e := c.expiration(d)
+	n := 0
+	c.items.rangeItems(func(k string, item Item) bool {
+		if c.expired(item) || !pred(k, item.Object) {
+			return true
+		}
+		item.Expiration = item.capExpiration(e)
+		c.putItem(k, item)
+		n++
+		return true
+	})
+	return n
+}
+
+// 返回最先过期的未过期数据项，忽略永不过期的数据项，没有设置过期时间的数据项时found为false
+func (c *Cache) NextToExpire() (key string, expiration time.Time, found bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var e int64
+	c.items.rangeItems(func(k string, v Item) bool {
+		if v.Expiration <= 0 || c.expired(v) {
+			return true
+		}
+		if !found || v.Expiration < e {
+			key, e, found = k, v.Expiration, true
+		}
+		return true
+	})
+	if found {
+		expiration = time.Unix(0, e)
+	}
+	return key, expiration, found
+}
+
 // 替换一个已经存在的数据项
 func (c *Cache) Replace(k string, v interface{}, d time.Duration) error {
 	c.mu.Lock()
+	if err := c.checkKey(k); err != nil {
+		c.unlock()
+		return err
+	}
 	_, found := c.get(k)
 	if !found {
-		c.mu.Unlock()
-		return fmt.Errorf("Item %s doesn't exist", k)
+		c.unlock()
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, k)
+	}
+	if err := c.checkWriteInterval(k); err != nil {
+		c.unlock()
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		c.unlock()
+		return err
 	}
 	c.set(k, v, d)
-	c.mu.Unlock()
+	c.unlock()
+	return nil
+}
+
+// 替换一个已经存在的数据项的值，保留原来的过期时间
+func (c *Cache) ReplaceKeepTTL(k string, v interface{}) error {
+	c.mu.Lock()
+	defer c.unlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, k)
+	}
+	if err := c.checkWriteInterval(k); err != nil {
+		return err
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		return err
+	}
+	item.Object = v
+	c.setItem(k, item)
 	return nil
 }
 
+// 判断数据项是否存在、未过期且值的动态类型为t
+func (c *Cache) HasType(k string, t reflect.Type) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, found := c.get(k)
+	return found && reflect.TypeOf(v) == t
+}
+
+// 设置新的数据项并返回旧值，旧数据项不存在或已过期时hadOld为false
+// key不合法、距离上一次写入不足SetMinWriteInterval或开启SetCopyOnSet时无法复制v时不写入，仍返回当前的值
+func (c *Cache) GetSet(k string, v interface{}, d time.Duration) (old interface{}, hadOld bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	old, hadOld = c.get(k)
+	if c.checkKey(k) != nil || c.checkWriteInterval(k) != nil {
+		return old, hadOld
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		return old, hadOld
+	}
+	c.set(k, v, d)
+	return old, hadOld
+}
+
+// 在写锁内用f的返回值替换每个未过期数据项的值，过期时间保持不变，返回处理的数据项数量
+// f返回nil时删除该数据项(会调用OnEvicted回调)，f中不能调用缓存的方法
+func (c *Cache) MapValues(f func(k string, v interface{}) interface{}) int {
+	var evictedItems []keyAndValue
+	c.mu.Lock()
+	n := 0
+	c.items.rangeItems(func(k string, item Item) bool {
+		if c.expired(item) {
+			return true
+		}
+		n++
+		nv := f(k, item.Object)
+		if nv == nil {
+			if ov, evicted := c.delete(k); evicted {
+				evictedItems = append(evictedItems, keyAndValue{k, ov})
+			}
+			return true
+		}
+		item.Object = nv
+		c.setItem(k, item)
+		return true
+	})
+	notify := c.evictedNotifier(evictedItems)
+	c.unlock()
+	notify()
+	return n
+}
+
 // 删除一个数据项
 func (c *Cache) Delete(k string) {
 	c.mu.Lock()
-	c.delete(k)
-	c.mu.Unlock()
+	v, evicted := c.delete(k)
+	onEvicted := c.onEvicted
+	c.unlock()
+	if evicted {
+		onEvicted(k, v)
+	}
 }
 
-// 将缓存数据项写入到io.Writer中
-func (c *Cache) Save(w io.Writer) (err error) {
-	enc := gob.NewEncoder(w)
-	defer func() {
-		if x := recover(); x != nil {
-			err = fmt.Errorf("Error registering from types with Gob library")
+// 当数据项存在、未过期且值满足pred时删除它，返回是否删除
+// 数据项不存在或已过期时不会调用pred，pred在锁内执行，不能调用缓存的方法
+func (c *Cache) DeleteIf(k string, pred func(v interface{}) bool) bool {
+	c.mu.Lock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) || !pred(item.Object) {
+		c.unlock()
+		return false
+	}
+	v, evicted := c.delete(k)
+	onEvicted := c.onEvicted
+	c.unlock()
+	if evicted {
+		onEvicted(k, v)
+	}
+	return true
+}
+
+// 当数据项存在、未过期且当前值满足pred时，以过期时间d写入新值v，返回是否替换，开启SetCopyOnSet时无法复制v也不会替换
+// 数据项不存在、已过期或距离上一次写入不足SetMinWriteInterval时不会调用pred，pred在锁内执行，不能调用缓存的方法
+func (c *Cache) ReplaceIf(k string, pred func(current interface{}) bool, v interface{}, d time.Duration) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) || c.checkWriteInterval(k) != nil || !pred(item.Object) {
+		return false
+	}
+	v, err := c.copyOnSetValue(v)
+	if err != nil {
+		return false
+	}
+	c.set(k, v, d)
+	return true
+}
+
+// 删除key为prefix以及所有以prefix加分隔符sep开头的数据项，返回删除的数量
+// 按层级匹配，例如InvalidateTree("a/b", "/")会删除a/b和a/b/c，但不会删除a/bc
+func (c *Cache) InvalidateTree(prefix string, sep string) int {
+	var evictedItems []keyAndValue
+	sub := prefix + sep
+	c.mu.Lock()
+	n := 0
+	c.items.rangeItems(func(k string, _ Item) bool {
+		if k != prefix && !strings.HasPrefix(k, sub) {
+			return true
 		}
-	}()
+		if v, evicted := c.delete(k); evicted {
+			evictedItems = append(evictedItems, keyAndValue{k, v})
+		}
+		n++
+		return true
+	})
+	notify := c.evictedNotifier(evictedItems)
+	c.unlock()
+	notify()
+	return n
+}
+
+// 删除写入时间早于age之前的未过期数据项，与各数据项的过期时间无关，返回删除的数量
+// 写入时间只在写入值时更新，通过GetRefresh、TouchFunc续期过的旧数据项同样会被删除
+// 没有记录写入时间的数据项(Written为0，例如从旧版本文件加载的)不会被删除
+func (c *Cache) DeleteOlderThan(age time.Duration) int {
+	var evictedItems []keyAndValue
+	c.mu.Lock()
+	cutoff := c.now() - int64(age)
+	n := 0
+	c.items.rangeItems(func(k string, v Item) bool {
+		if v.Written == 0 || v.Written >= cutoff || c.expired(v) {
+			return true
+		}
+		if ov, evicted := c.delete(k); evicted {
+			evictedItems = append(evictedItems, keyAndValue{k, ov})
+		}
+		n++
+		return true
+	})
+	notify := c.evictedNotifier(evictedItems)
+	c.unlock()
+	notify()
+	return n
+}
+
+// 将缓存数据项写入到io.Writer中
+// 开启写时复制(SetCopyOnWriteSave)时，Save只短暂加锁获取当前数据项的引用，编码在锁外进行
+func (c *Cache) Save(w io.Writer) error {
 	c.mu.RLock()
+	if c.cowSave {
+		c.mu.RUnlock()
+		return c.saveCopyOnWrite(w)
+	}
 	defer c.mu.RUnlock()
-	for _, v := range c.items {
-		gob.Register(v.Object)
+	return c.getCodec().Encode(w, itemsMap(c.items))
+}
+
+// 将缓存数据项写入到io.Writer中，只在复制数据项时短暂持有读锁，
+// 编码和写入在锁外进行，适用于较慢的io.Writer，写入的是复制时刻的一致快照
+func (c *Cache) SaveSnapshot(w io.Writer) error {
+	c.mu.RLock()
+	items := make(map[string]Item, c.items.len())
+	c.items.rangeItems(func(k string, v Item) bool {
+		items[k] = v
+		return true
+	})
+	codec := c.getCodec()
+	c.mu.RUnlock()
+	return codec.Encode(w, items)
+}
+
+// 只保存未过期数据项的key和过期时间(map[string]int64，永不过期为0)，不包含值，
+// 用于让其他进程以很小的开销了解缓存中有哪些数据。始终使用gob编码，与SetCodec无关
+func (c *Cache) SaveIndex(w io.Writer) error {
+	c.mu.RLock()
+	index := make(map[string]int64, c.items.len())
+	c.items.rangeItems(func(k string, v Item) bool {
+		if !c.expired(v) {
+			index[k] = v.Expiration
+		}
+		return true
+	})
+	c.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(index)
+}
+
+// 读取SaveIndex写入的key到过期时间的映射
+func LoadIndex(r io.Reader) (map[string]int64, error) {
+	index := map[string]int64{}
+	if err := gob.NewDecoder(r).Decode(&index); err != nil {
+		return nil, err
 	}
-	err = enc.Encode(&c.items)
-	return
+	return index, nil
 }
 
 // 保存数据项到文件中
@@ -202,24 +1380,70 @@ func (c *Cache) SaveToFile(file string) error {
 	return f.Close()
 }
 
-// 从io.Reader中读取数据项
+// 保存数据项到文件中，文件权限设置为perm，适用于保存敏感数据
+func (c *Cache) SaveToFileMode(file string, perm os.FileMode) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	// 文件已存在或受umask影响时，OpenFile不会使用perm，这里显式设置
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err = c.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// 从io.Reader中读取数据项，数据损坏或不完整时返回*LoadError，缓存不会被修改
 func (c *Cache) Load(r io.Reader) error {
-	dec := gob.NewDecoder(r)
-	items := map[string]Item{}
-	err := dec.Decode(&items)
+	items, err := c.decode(r)
 	if err == nil {
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		for k, v := range items {
-			ov, found := c.items[k]
-			if !found || ov.Expired() {
-				c.items[k] = v // 数据项不存在或失效，将数据项加入
-			}
-		}
+		c.merge(items)
 	}
 	return err
 }
 
+// 从io.Reader中读取数据项，并将所有非零的过期时间、最晚过期时间和写入时间加上skew，合并策略与Load相同
+// 用于加载在时钟不同步的机器上保存的数据：如果保存方的时钟比本机快d，skew传入-d
+func (c *Cache) LoadWithClockSkew(r io.Reader, skew time.Duration) error {
+	items, err := c.decode(r)
+	if err != nil {
+		return err
+	}
+	for k, v := range items {
+		if v.Expiration > 0 {
+			v.Expiration += int64(skew)
+		}
+		if v.Deadline > 0 {
+			v.Deadline += int64(skew)
+		}
+		if v.Written > 0 {
+			v.Written += int64(skew)
+		}
+		items[k] = v
+	}
+	c.merge(items)
+	return nil
+}
+
+// 将items合并到缓存中，只加入当前不存在或已失效的数据项，过期时间受最长存活时间限制
+func (c *Cache) merge(items map[string]Item) {
+	c.mu.Lock()
+	defer c.unlock()
+	for k, v := range items {
+		ov, found := c.items.get(k)
+		if !found || c.expired(ov) {
+			v.Expiration = c.clampExpiration(v.Expiration)
+			c.clearExpireCallback(k)
+			c.putItem(k, v) // 数据项不存在或失效，将数据项加入
+		}
+	}
+}
+
 // 从文件中加载缓存数据项
 func (c *Cache) LoadFile(file string) error {
 	f, err := os.Open(file)
@@ -233,33 +1457,287 @@ func (c *Cache) LoadFile(file string) error {
 	return f.Close()
 }
 
+// 每次加锁处理的数据项数量
+const loadBatchSize = 128
+
+// 从管道中读取数据项直到管道关闭，返回写入的数量
+// 已过期的数据项会被跳过，合并策略与Load相同：只有当前不存在或已失效的数据项才会被写入
+// 数据项按批次加锁写入，避免长时间阻塞其他操作
+func (c *Cache) LoadFromChannel(ch <-chan Entry) int {
+	n := 0
+	batch := make([]Entry, 0, loadBatchSize)
+	flush := func() {
+		c.mu.Lock()
+		for _, e := range batch {
+			ov, found := c.items.get(e.Key)
+			if !found || c.expired(ov) {
+				e.Item.Expiration = c.clampExpiration(e.Item.Expiration)
+				c.clearExpireCallback(e.Key)
+				c.putItem(e.Key, e.Item)
+				n++
+			}
+		}
+		c.unlock()
+		batch = batch[:0]
+	}
+	for e := range ch {
+		if c.expired(e.Item) {
+			continue
+		}
+		batch = append(batch, e)
+		if len(batch) == loadBatchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+	return n
+}
+
+// 将所有缓存数据项导出为字节切片
+func (c *Cache) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 从Snapshot导出的字节切片恢复缓存
+// 与Load的合并策略不同，Restore会用快照中未过期的数据项替换当前全部内容
+func (c *Cache) Restore(data []byte) error {
+	return c.LoadReplace(bytes.NewReader(data))
+}
+
+// 从io.Reader中读取数据项，并用其中未过期的数据项替换当前全部内容，不在其中的数据项会被丢弃
+// 与合并写入的Load不同，替换在一次写锁内完成
+func (c *Cache) LoadReplace(r io.Reader) error {
+	items, err := c.decode(r)
+	if err != nil {
+		return err
+	}
+	for k, v := range items {
+		if c.expired(v) {
+			delete(items, k)
+		}
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	for k, v := range items {
+		v.Expiration = c.clampExpiration(v.Expiration)
+		items[k] = v
+	}
+	c.replaceItems(items)
+	return nil
+}
+
+// 按剩余存活时间统计未过期数据项的数量，分桶固定为：
+// "<1m"、"1m-10m"、"10m-1h"、">1h"，以及永不过期的"none"，每个桶左闭右开
+func (c *Cache) ExpirationHistogram() map[string]int {
+	h := map[string]int{"<1m": 0, "1m-10m": 0, "10m-1h": 0, ">1h": 0, "none": 0}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	c.items.rangeItems(func(_ string, v Item) bool {
+		if c.expired(v) {
+			return true
+		}
+		if v.Expiration == 0 {
+			h["none"]++
+			return true
+		}
+		switch ttl := time.Duration(v.Expiration - now); {
+		case ttl < time.Minute:
+			h["<1m"]++
+		case ttl < 10*time.Minute:
+			h["1m-10m"]++
+		case ttl < time.Hour:
+			h["10m-1h"]++
+		default:
+			h[">1h"]++
+		}
+		return true
+	})
+	return h
+}
+
+// 一个key及其数据项
+type Entry struct {
+	Key  string
+	Item Item
+}
+
+// 返回所有未过期的数据项，按key的字典序排列，用于生成稳定的输出
+func (c *Cache) SortedItems() []Entry {
+	c.mu.RLock()
+	entries := make([]Entry, 0, c.items.len())
+	c.items.rangeItems(func(k string, v Item) bool {
+		if !c.expired(v) {
+			entries = append(entries, Entry{Key: k, Item: v})
+		}
+		return true
+	})
+	c.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// 按key的字典序将未过期的数据项以"key\t过期时间\t值"的格式逐行写入w，用于调试
+func (c *Cache) DumpDebug(w io.Writer) error {
+	for _, e := range c.SortedItems() {
+		exp := "never"
+		if e.Item.Expiration > 0 {
+			exp = time.Unix(0, e.Item.Expiration).Format(time.RFC3339Nano)
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%v\n", e.Key, exp, e.Item.Object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DebugJSON输出的单个数据项
+type debugEntry struct {
+	Value      json.RawMessage `json:"value"`
+	ExpiresAt  *time.Time      `json:"expiresAt"`  // 永不过期时为null
+	TTLSeconds *float64        `json:"ttlSeconds"` // 永不过期时为null
+}
+
+// 将未过期的数据项编码为{key: {value, expiresAt, ttlSeconds}}格式的JSON，用于HTTP调试接口
+// 无法编码为JSON的值会输出为它的类型名
+func (c *Cache) DebugJSON() ([]byte, error) {
+	now := c.now()
+	out := map[string]debugEntry{}
+	for _, e := range c.SortedItems() {
+		var de debugEntry
+		b, err := json.Marshal(e.Item.Object)
+		if err != nil {
+			b, _ = json.Marshal(fmt.Sprintf("%T", e.Item.Object))
+		}
+		de.Value = b
+		if e.Item.Expiration > 0 {
+			t := time.Unix(0, e.Item.Expiration)
+			ttl := time.Duration(e.Item.Expiration - now).Seconds()
+			de.ExpiresAt, de.TTLSeconds = &t, &ttl
+		}
+		out[e.Key] = de
+	}
+	return json.Marshal(out)
+}
+
+// 返回值与v相等(reflect.DeepEqual)的所有未过期数据项的key，按字典序排序
+// 需要遍历全部数据项，适用于数据量较小的缓存或调试
+func (c *Cache) KeysWithValue(v interface{}) []string {
+	var keys []string
+	c.mu.RLock()
+	c.items.rangeItems(func(k string, item Item) bool {
+		if !c.expired(item) && reflect.DeepEqual(item.Object, v) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	c.mu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// 按group计算出的分组统计未过期数据项的数量，group在读锁内执行，不能调用缓存的写方法
+func (c *Cache) CountBy(group func(k string, v interface{}) string) map[string]int {
+	counts := map[string]int{}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.items.rangeItems(func(k string, v Item) bool {
+		if !c.expired(v) {
+			counts[group(k, v.Object)]++
+		}
+		return true
+	})
+	return counts
+}
+
 // 返回缓存数据想的数量
 func (c *Cache) Count() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return len(c.items)
+	return c.items.len()
 }
 
-// 清空缓存
-func (c *Cache) Flush() {
+// 清空缓存，返回被清空的数据项数量(包括已过期但尚未被清理的数据项)
+func (c *Cache) Flush() int {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.items = map[string]Item{}
+	defer c.unlock()
+	n := c.items.len()
+	c.replaceItems(map[string]Item{})
+	return n
 }
 
-// 停止过期缓存清理
+// 取出全部数据项(包括过期时间)并清空缓存，与Flush不同，数据项会被返回而不是丢弃
+func (c *Cache) Drain() map[string]Item {
+	c.mu.Lock()
+	defer c.unlock()
+	c.beforeWrite()
+	items := itemsMap(c.items)
+	c.replaceItems(map[string]Item{})
+	return items
+}
+
+// 返回缓存的代数，每次Flush、Restore等整体替换数据项的操作都会使其加一
+// 可以通过比较代数判断两次观察之间是否发生过整体变更
+func (c *Cache) Generation() uint64 {
+	return atomic.LoadUint64(&c.generation)
+}
+
+// 返回缓存的运行状态，可用于健康检查
+func (c *Cache) Status() CacheStatus {
+	st := CacheStatus{
+		ItemCount:     c.Count(),
+		GCRunning:     atomic.LoadInt32(&c.gcRunning) == 1,
+		LastGCRemoved: int(atomic.LoadInt64(&c.lastGCRemoved)),
+		GCInterval:    time.Duration(atomic.LoadInt64(&c.curGCInterval)),
+	}
+	if t := atomic.LoadInt64(&c.lastGC); t > 0 {
+		st.LastGCTime = time.Unix(0, t)
+	}
+	return st
+}
+
+// 停止过期缓存清理，同时停止时钟刷新、WatchFile等后台协程
+// 通知不会阻塞，即使清理协程已经退出，StopGC也会立即返回；
+// 唯一的例外是开启了EnableDebouncedSave时，StopGC会等待最后一次保存完成
 func (c *Cache) StopGC() {
-	c.stopGC <- true
+	c.stopBackground()
+	c.saveWG.Wait()
+	select {
+	case c.stopGC <- true:
+	default: // 已经有一个未被处理的停止通知
+	}
+}
+
+// 通知所有后台协程退出，可以重复调用
+func (c *Cache) stopBackground() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
 }
 
 // 创建一个缓存系统
 func NewCache(defaultExpiration, gcInterval time.Duration) *Cache {
-	c := &Cache{
+	return newCacheWithStore(defaultExpiration, gcInterval, nil)
+}
+
+// 创建一个不启动清理协程的缓存
+func newCache(defaultExpiration, gcInterval time.Duration) *Cache {
+	if defaultExpiration == DefaultExpiration {
+		defaultExpiration = NoExpiration
+	}
+	return &Cache{
 		DefaultExpiration: defaultExpiration,
 		gcInterval:        gcInterval,
-		items:             map[string]Item{},
-		stopGC:            make(chan bool),
+		items:             mapStore{},
+		stopGC:            make(chan bool, 1),
+		done:              make(chan struct{}),
 	}
-	go c.gcLoop()
-	return c
 }
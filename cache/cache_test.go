@@ -0,0 +1,1359 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 等待cond成立，最多等待timeout
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStatusLastGCTimeAdvances(t *testing.T) {
+	c := NewCache(time.Minute, 5*time.Millisecond)
+	c.Set("a", 1, time.Millisecond)
+	c.Set("b", 2, 0)
+	waitFor(t, time.Second, func() bool { return !c.Status().LastGCTime.IsZero() })
+	st := c.Status()
+	if !st.GCRunning {
+		t.Fatal("GCRunning = false while the GC loop is alive")
+	}
+	first := st.LastGCTime
+	waitFor(t, time.Second, func() bool { return c.Status().LastGCTime.After(first) })
+	st = c.Status()
+	if st.ItemCount != 1 {
+		t.Fatalf("ItemCount = %d, want 1", st.ItemCount)
+	}
+	c.StopGC()
+	waitFor(t, time.Second, func() bool { return !c.Status().GCRunning })
+}
+
+func TestGetSetReadAndReset(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("counter", int64(0), 0)
+	for i := 0; i < 7; i++ {
+		c.IncrOrInit("counter", 1, 0)
+	}
+	old, hadOld := c.GetSet("counter", int64(0), 0)
+	if !hadOld || old != int64(7) {
+		t.Fatalf("GetSet = %v, %v, want 7, true", old, hadOld)
+	}
+	if v, _ := c.Get("counter"); v != int64(0) {
+		t.Fatalf("counter = %v after reset, want 0", v)
+	}
+
+	c.Set("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if old, hadOld := c.GetSet("expired", 2, 0); hadOld || old != nil {
+		t.Fatalf("GetSet on an expired key = %v, %v, want nil, false", old, hadOld)
+	}
+	if _, hadOld := c.GetSet("fresh", 1, 0); hadOld {
+		t.Fatal("GetSet on a missing key reported an old value")
+	}
+}
+
+func TestMaxKeyLength(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetMaxKeyLength(4)
+	if err := c.Set("toolong", 1, 0); err == nil {
+		t.Fatal("Set accepted a key over the limit")
+	}
+	if err := c.Add("toolong", 1, 0); err == nil {
+		t.Fatal("Add accepted a key over the limit")
+	}
+	if err := c.Replace("toolong", 1, 0); err == nil {
+		t.Fatal("Replace accepted a key over the limit")
+	}
+	if err := c.Set("ok", 1, 0); err != nil {
+		t.Fatalf("Set rejected a compliant key: %v", err)
+	}
+	c.GetSet("toolong", 1, 0)
+	c.IncrOrInit("toolong", 1, 0)
+	c.Hit("toolong", time.Minute)
+	if _, _, err := c.IncrementIfBelow("toolong", 1, 10, 0); err == nil {
+		t.Fatal("IncrementIfBelow accepted a key over the limit")
+	}
+	if _, found := c.Get("toolong"); found {
+		t.Fatal("over-length key was stored")
+	}
+	c.SetMaxKeyLength(0)
+	if err := c.Set("toolong", 1, 0); err != nil {
+		t.Fatalf("Set rejected a key with no limit: %v", err)
+	}
+}
+
+func TestRejectEmptyKeys(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	if err := c.Set("", 1, 0); err != nil {
+		t.Fatalf("Set rejected an empty key by default: %v", err)
+	}
+	c.SetRejectEmptyKeys(true)
+	if err := c.Set("", 2, 0); err == nil {
+		t.Fatal("Set accepted an empty key")
+	}
+	if err := c.Replace("", 2, 0); err == nil {
+		t.Fatal("Replace accepted an empty key")
+	}
+	c.Delete("")
+	if err := c.Add("", 2, 0); err == nil {
+		t.Fatal("Add accepted an empty key")
+	}
+	if _, found := c.Get(""); found {
+		t.Fatal("empty key was stored")
+	}
+	c.SetRejectEmptyKeys(false)
+	if err := c.Add("", 3, 0); err != nil {
+		t.Fatalf("Add rejected an empty key after disabling: %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", 1, time.Hour)
+	c.Set("b", "two", NoExpiration)
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewCache(time.Minute, time.Hour)
+	d.Set("stale", 3, 0)
+	if err := d.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.items, d.items) {
+		t.Fatalf("restored %v, want %v", d.items, c.items)
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetGracePeriod(50 * time.Millisecond)
+	c.Set("a", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c.DeleteExpired()
+	if _, found := c.Get("a"); found {
+		t.Fatal("Get returned an expired item inside the grace period")
+	}
+	if v, stale, found := c.GetStale("a"); !found || !stale || v != 1 {
+		t.Fatalf("GetStale in grace = %v, %v, %v, want 1, true, true", v, stale, found)
+	}
+	time.Sleep(50 * time.Millisecond)
+	c.DeleteExpired()
+	if _, _, found := c.GetStale("a"); found {
+		t.Fatal("item survived past expiration plus grace")
+	}
+	if c.Count() != 0 {
+		t.Fatalf("Count() = %d after grace, want 0", c.Count())
+	}
+}
+
+func TestGetRefresh(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", 1, 10*time.Millisecond)
+	before := time.Now()
+	v, found := c.GetRefresh("a", time.Hour)
+	if !found || v != 1 {
+		t.Fatalf("GetRefresh = %v, %v, want 1, true", v, found)
+	}
+	exp := time.Unix(0, c.itemOf("a").Expiration)
+	if exp.Before(before.Add(time.Hour)) || exp.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("expiration %v is not now+1h", exp)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, found := c.Get("a"); !found {
+		t.Fatal("refreshed item expired at its old TTL")
+	}
+	if _, found := c.GetRefresh("missing", time.Hour); found {
+		t.Fatal("GetRefresh found a missing key")
+	}
+}
+
+func TestSetLazyExpirationOff(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetLazyExpiration(false)
+	c.Set("k", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if v, found := c.Get("k"); !found || v != "v" {
+		t.Fatalf("Get with lazy expiration off = %v, %v, want v, true", v, found)
+	}
+	c.DeleteExpired()
+	if _, found := c.Get("k"); found {
+		t.Fatal("DeleteExpired did not remove the expired item")
+	}
+
+	c.SetLazyExpiration(true)
+	c.Set("k", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, found := c.Get("k"); found {
+		t.Fatal("Get returned an expired item with lazy expiration on")
+	}
+}
+
+func TestOnHighWaterMark(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var fired []int
+	c.OnHighWaterMark(3, func(n int) { fired = append(fired, n) })
+	for i := 0; i < 6; i++ {
+		c.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	if !reflect.DeepEqual(fired, []int{4}) {
+		t.Fatalf("callback calls = %v, want [4]", fired)
+	}
+
+	c.Flush()
+	c.GetSet("a", 1, DefaultExpiration)
+	c.IncrOrInit("b", 1, DefaultExpiration)
+	c.IncrOrInit("c", 1, DefaultExpiration)
+	if len(fired) != 1 {
+		t.Fatalf("callback fired at or below the threshold: %v", fired)
+	}
+	c.IncrOrInit("d", 1, DefaultExpiration)
+	if !reflect.DeepEqual(fired, []int{4, 4}) {
+		t.Fatalf("callback calls = %v, want [4 4] after crossing again via IncrOrInit", fired)
+	}
+}
+
+func TestMapValues(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, NoExpiration)
+	c.Set("drop", 3, NoExpiration)
+	before := c.itemOf("a")
+	n := c.MapValues(func(k string, v interface{}) interface{} {
+		if k == "drop" {
+			return nil
+		}
+		return strconv.Itoa(v.(int))
+	})
+	if n != 3 {
+		t.Fatalf("MapValues() = %d, want 3", n)
+	}
+	if v, _ := c.Get("a"); v != "1" {
+		t.Fatalf("a = %v (%T), want \"1\"", v, v)
+	}
+	if v, _ := c.Get("b"); v != "2" {
+		t.Fatalf("b = %v (%T), want \"2\"", v, v)
+	}
+	if _, found := c.Get("drop"); found {
+		t.Fatal("item mapped to nil was not deleted")
+	}
+	if after := c.itemOf("a"); after.Expiration != before.Expiration {
+		t.Fatal("MapValues changed the expiration")
+	}
+}
+
+func TestStoredNil(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("nil", nil, DefaultExpiration)
+	if v, found := c.Get("nil"); v != nil || !found {
+		t.Fatalf("Get(stored nil) = %v, %v, want nil, true", v, found)
+	}
+	if v, found := c.Get("absent"); v != nil || found {
+		t.Fatalf("Get(absent) = %v, %v, want nil, false", v, found)
+	}
+}
+
+func TestSaveToFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not supported on windows")
+	}
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("secret", "token", DefaultExpiration)
+	file := filepath.Join(t.TempDir(), "cache.gob")
+	if err := c.SaveToFileMode(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Fatalf("file mode = %v, want 0600", perm)
+	}
+	loaded := NewCache(time.Minute, time.Hour)
+	if err := loaded.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := loaded.Get("secret"); v != "token" {
+		t.Fatalf("loaded value = %v, want token", v)
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	g := c.Generation()
+	c.Set("a", 1, DefaultExpiration)
+	c.Delete("a")
+	if c.Generation() != g {
+		t.Fatal("Generation changed after a single-key write")
+	}
+	c.Flush()
+	if c.Generation() <= g {
+		t.Fatal("Generation did not increase after Flush")
+	}
+	g = c.Generation()
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if c.Generation() <= g {
+		t.Fatal("Generation did not increase after Restore")
+	}
+}
+
+func TestNextToExpire(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("forever", 0, NoExpiration)
+	if _, _, found := c.NextToExpire(); found {
+		t.Fatal("NextToExpire found an item although none has a TTL")
+	}
+	c.Set("later", 1, time.Hour)
+	c.Set("soon", 2, time.Minute)
+	c.Set("gone", 3, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	k, exp, found := c.NextToExpire()
+	if !found || k != "soon" {
+		t.Fatalf("NextToExpire() = %q, %v, want soon, true", k, found)
+	}
+	if item := c.itemOf("soon"); !exp.Equal(time.Unix(0, item.Expiration)) {
+		t.Fatalf("expiration = %v, want %v", exp, time.Unix(0, item.Expiration))
+	}
+}
+
+func TestAdaptiveGC(t *testing.T) {
+	c := NewCache(time.Minute, 40*time.Millisecond)
+	defer c.StopGC()
+	for _, r := range [][2]time.Duration{{0, time.Second}, {-time.Millisecond, time.Second}, {time.Second, time.Millisecond}} {
+		if err := c.SetAdaptiveGC(r[0], r[1]); err == nil {
+			t.Fatalf("SetAdaptiveGC(%v, %v) accepted an invalid range", r[0], r[1])
+		}
+	}
+	if c.gcMax != 0 {
+		t.Fatal("a rejected range changed the adaptive GC setting")
+	}
+	if err := c.SetAdaptiveGC(5*time.Millisecond, 80*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.adaptGCInterval(40*time.Millisecond, 10, 5); d != 20*time.Millisecond {
+		t.Fatalf("interval after a busy sweep = %v, want 20ms", d)
+	}
+	if d := c.adaptGCInterval(40*time.Millisecond, 0, 0.5); d != 80*time.Millisecond {
+		t.Fatalf("interval after idle sweeps = %v, want 80ms", d)
+	}
+	if d := c.adaptGCInterval(80*time.Millisecond, 0, 0); d != 80*time.Millisecond {
+		t.Fatalf("interval above max = %v, want 80ms", d)
+	}
+	if d := c.adaptGCInterval(5*time.Millisecond, 10, 5); d != 5*time.Millisecond {
+		t.Fatalf("interval below min = %v, want 5ms", d)
+	}
+
+	// 持续产生过期数据项，清理周期应缩短到下限
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c.Set(strconv.Itoa(i), i, time.Millisecond)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	waitFor(t, 2*time.Second, func() bool { return c.Status().GCInterval == 5*time.Millisecond })
+}
+
+func TestSetManyReport(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("old", 1, DefaultExpiration)
+	c.Set("expired", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	created, overwritten := c.SetManyReport(map[string]interface{}{
+		"old": 10, "expired": 20, "new": 30, "b": 40,
+	}, DefaultExpiration)
+	if !reflect.DeepEqual(created, []string{"b", "expired", "new"}) {
+		t.Fatalf("created = %v, want [b expired new]", created)
+	}
+	if !reflect.DeepEqual(overwritten, []string{"old"}) {
+		t.Fatalf("overwritten = %v, want [old]", overwritten)
+	}
+	if v, _ := c.Get("old"); v != 10 {
+		t.Fatalf("old = %v, want 10", v)
+	}
+}
+
+func TestHasType(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("s", "str", DefaultExpiration)
+	c.Set("old", "str", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	strType := reflect.TypeOf("")
+	if !c.HasType("s", strType) {
+		t.Fatal("HasType = false for a matching type")
+	}
+	if c.HasType("s", reflect.TypeOf(0)) {
+		t.Fatal("HasType = true for a mismatching type")
+	}
+	if c.HasType("missing", strType) {
+		t.Fatal("HasType = true for a missing key")
+	}
+	if c.HasType("old", strType) {
+		t.Fatal("HasType = true for an expired key")
+	}
+}
+
+func TestReplaceKeepTTL(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("k", "v1", time.Hour)
+	before := c.itemOf("k")
+	time.Sleep(time.Millisecond)
+	if err := c.ReplaceKeepTTL("k", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	after := c.itemOf("k")
+	if after.Object != "v2" {
+		t.Fatalf("value = %v, want v2", after.Object)
+	}
+	if after.Expiration != before.Expiration {
+		t.Fatalf("expiration changed from %d to %d", before.Expiration, after.Expiration)
+	}
+	if err := c.ReplaceKeepTTL("missing", 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+// 在release被关闭之前阻塞每一次写入
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	return len(p), nil
+}
+
+func TestSaveSnapshotDoesNotBlockWriters(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", 1, DefaultExpiration)
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	saved := make(chan error, 1)
+	go func() { saved <- c.SaveSnapshot(w) }()
+	<-w.started
+
+	written := make(chan struct{})
+	go func() {
+		c.Set("b", 2, DefaultExpiration)
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Set blocked while SaveSnapshot was writing")
+	}
+	close(w.release)
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestItemSize(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetWithSize("a", "aaa", DefaultExpiration, 100)
+	c.SetWithSize("b", "bb", DefaultExpiration, 20)
+	c.Set("plain", "p", DefaultExpiration)
+	if n := c.TotalSize(); n != 120 {
+		t.Fatalf("TotalSize() = %d, want 120", n)
+	}
+	if n, found := c.ItemSize("a"); !found || n != 100 {
+		t.Fatalf("ItemSize(a) = %d, %v, want 100, true", n, found)
+	}
+	if n, found := c.ItemSize("plain"); !found || n != 0 {
+		t.Fatalf("ItemSize(plain) = %d, %v, want 0, true", n, found)
+	}
+	c.Delete("a")
+	if n := c.TotalSize(); n != 20 {
+		t.Fatalf("TotalSize() after delete = %d, want 20", n)
+	}
+
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewCache(time.Minute, time.Hour)
+	if err := d.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := d.ItemSize("b"); n != 20 {
+		t.Fatalf("ItemSize(b) after restore = %d, want 20", n)
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("k", "sentinel", DefaultExpiration)
+	c.Set("other", "value", DefaultExpiration)
+	isSentinel := func(v interface{}) bool { return v == "sentinel" }
+	if c.DeleteIf("other", isSentinel) {
+		t.Fatal("DeleteIf deleted a value not matching the predicate")
+	}
+	if _, found := c.Get("other"); !found {
+		t.Fatal("non-matching value was removed")
+	}
+	if !c.DeleteIf("k", isSentinel) {
+		t.Fatal("DeleteIf did not delete the matching value")
+	}
+	if _, found := c.Get("k"); found {
+		t.Fatal("matching value is still cached")
+	}
+	called := false
+	if c.DeleteIf("missing", func(interface{}) bool { called = true; return true }) || called {
+		t.Fatal("DeleteIf called pred for a missing key")
+	}
+}
+
+func TestGetOrdered(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", 1, DefaultExpiration)
+	c.Set("b", 2, DefaultExpiration)
+	got := c.GetOrdered([]string{"b", "x", "a", "b", "y"})
+	want := []Result{
+		{Key: "b", Value: 2, Found: true},
+		{Key: "x"},
+		{Key: "a", Value: 1, Found: true},
+		{Key: "b", Value: 2, Found: true},
+		{Key: "y"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetOrdered() = %v, want %v", got, want)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, NoExpiration)
+	want := c.itemOf("a")
+	items := c.Drain()
+	if len(items) != 2 || !reflect.DeepEqual(items["a"], want) || items["b"].Object != 2 || items["b"].Expiration != 0 {
+		t.Fatalf("Drain() = %v, want both items with their expirations", items)
+	}
+	if c.Count() != 0 {
+		t.Fatalf("Count() after Drain = %d, want 0", c.Count())
+	}
+	c.Set("c", 3, DefaultExpiration)
+	if _, found := items["c"]; found {
+		t.Fatal("writes after Drain are visible in the drained map")
+	}
+}
+
+func TestInvalidateTree(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for _, k := range []string{"a/b", "a/b/c", "a/b/c/d", "a/bc", "a", "x/a/b"} {
+		c.Set(k, k, DefaultExpiration)
+	}
+	if n := c.InvalidateTree("a/b", "/"); n != 3 {
+		t.Fatalf("InvalidateTree() = %d, want 3", n)
+	}
+	for _, k := range []string{"a/b", "a/b/c", "a/b/c/d"} {
+		if _, found := c.Get(k); found {
+			t.Fatalf("%s was not invalidated", k)
+		}
+	}
+	for _, k := range []string{"a/bc", "a", "x/a/b"} {
+		if _, found := c.Get(k); !found {
+			t.Fatalf("%s was invalidated", k)
+		}
+	}
+}
+
+func TestMaxTTL(t *testing.T) {
+	// 检查k的过期时间在现在加一小时附近
+	checkCapped := func(t *testing.T, c *Cache, k string) {
+		t.Helper()
+		item, found := c.items.get(k)
+		if !found {
+			t.Fatalf("%s not found", k)
+		}
+		left := time.Until(time.Unix(0, item.Expiration))
+		if item.Expiration == 0 || left > time.Hour || left < time.Hour-time.Minute {
+			t.Fatalf("%s expires in %v, want about 1h", k, left)
+		}
+	}
+	c := NewCache(NoExpiration, time.Hour)
+	c.SetMaxTTL(time.Hour)
+	c.Set("forever", 1, NoExpiration)
+	c.Set("long", 2, 24*time.Hour)
+	c.Set("short", 3, time.Minute)
+	checkCapped(t, c, "forever")
+	checkCapped(t, c, "long")
+	if item := c.itemOf("short"); time.Until(time.Unix(0, item.Expiration)) > time.Minute {
+		t.Fatal("a TTL below the cap was extended")
+	}
+
+	src := NewCache(NoExpiration, time.Hour)
+	src.Set("forever", 1, NoExpiration)
+	src.Set("long", 2, 24*time.Hour)
+	data, err := src.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Load", func(t *testing.T) {
+		d := NewCache(NoExpiration, time.Hour)
+		d.SetMaxTTL(time.Hour)
+		if err := d.Load(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		checkCapped(t, d, "forever")
+		checkCapped(t, d, "long")
+	})
+	t.Run("Restore", func(t *testing.T) {
+		d := NewCache(NoExpiration, time.Hour)
+		d.SetMaxTTL(time.Hour)
+		if err := d.Restore(data); err != nil {
+			t.Fatal(err)
+		}
+		checkCapped(t, d, "forever")
+		checkCapped(t, d, "long")
+	})
+	t.Run("LoadFromChannel", func(t *testing.T) {
+		d := NewCache(NoExpiration, time.Hour)
+		d.SetMaxTTL(time.Hour)
+		ch := make(chan Entry, 1)
+		ch <- Entry{Key: "forever", Item: Item{Object: 1}}
+		close(ch)
+		d.LoadFromChannel(ch)
+		checkCapped(t, d, "forever")
+	})
+	t.Run("ReplayWAL", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "cache.wal")
+		w := NewCache(NoExpiration, time.Hour)
+		if err := w.EnableWAL(file); err != nil {
+			t.Fatal(err)
+		}
+		w.Set("forever", 1, NoExpiration)
+		w.DisableWAL()
+		d := NewCache(NoExpiration, time.Hour)
+		d.SetMaxTTL(time.Hour)
+		if err := d.ReplayWAL(file); err != nil {
+			t.Fatal(err)
+		}
+		checkCapped(t, d, "forever")
+	})
+
+	e := NewCache(NoExpiration, time.Hour)
+	e.SetMaxTTL(5 * time.Millisecond)
+	e.Set("forever", 1, NoExpiration)
+	time.Sleep(10 * time.Millisecond)
+	if _, found := e.Get("forever"); found {
+		t.Fatal("no-expiration item outlived the max TTL")
+	}
+}
+
+func TestAddReplaceErrors(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("k", 1, DefaultExpiration)
+	err := c.Add("k", 2, DefaultExpiration)
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("Add err = %v, want ErrKeyExists", err)
+	}
+	if err.Error() != "Item already exists: k" {
+		t.Fatalf("Add err = %q, want \"Item already exists: k\"", err)
+	}
+	err = c.Replace("missing", 1, DefaultExpiration)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Replace err = %v, want ErrKeyNotFound", err)
+	}
+	if err.Error() != "Item doesn't exist: missing" {
+		t.Fatalf("Replace err = %q, want \"Item doesn't exist: missing\"", err)
+	}
+}
+
+func TestExpirationHistogram(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", 1, 30*time.Second)
+	c.Set("b", 1, 5*time.Minute)
+	c.Set("c", 1, 6*time.Minute)
+	c.Set("d", 1, 30*time.Minute)
+	c.Set("e", 1, 2*time.Hour)
+	c.Set("f", 1, NoExpiration)
+	c.Set("gone", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	want := map[string]int{"<1m": 1, "1m-10m": 2, "10m-1h": 1, ">1h": 1, "none": 1}
+	if got := c.ExpirationHistogram(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ExpirationHistogram() = %v, want %v", got, want)
+	}
+}
+
+func TestTouchFunc(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for i := 1; i <= 4; i++ {
+		c.Set(strconv.Itoa(i), i, time.Minute)
+	}
+	n := c.TouchFunc(time.Hour, func(_ string, v interface{}) bool { return v.(int) > 2 })
+	if n != 2 {
+		t.Fatalf("TouchFunc() = %d, want 2", n)
+	}
+	for i := 1; i <= 4; i++ {
+		item := c.itemOf(strconv.Itoa(i))
+		left := time.Until(time.Unix(0, item.Expiration))
+		if extended := left > time.Minute; extended != (i > 2) {
+			t.Fatalf("item %d expires in %v, extended = %v, want %v", i, left, extended, i > 2)
+		}
+	}
+}
+
+func TestSortedItems(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for _, k := range []string{"m", "c", "x", "a", "k"} {
+		c.Set(k, k, DefaultExpiration)
+	}
+	entries := c.SortedItems()
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+		if e.Item.Object != e.Key {
+			t.Fatalf("entry %s has value %v", e.Key, e.Item.Object)
+		}
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "c", "k", "m", "x"}) {
+		t.Fatalf("SortedItems keys = %v, want sorted", keys)
+	}
+	var buf bytes.Buffer
+	if err := c.DumpDebug(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var dumped []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		dumped = append(dumped, strings.SplitN(line, "\t", 2)[0])
+	}
+	if !reflect.DeepEqual(dumped, keys) {
+		t.Fatalf("DumpDebug keys = %v, want %v", dumped, keys)
+	}
+}
+
+func TestDefaultExpirationResolution(t *testing.T) {
+	never := NewCache(DefaultExpiration, time.Hour)
+	if never.DefaultExpiration != NoExpiration {
+		t.Fatalf("DefaultExpiration = %v, want NoExpiration", never.DefaultExpiration)
+	}
+	never.Set("default", 1, DefaultExpiration)
+	never.Add("added", 1, DefaultExpiration)
+	never.Set("negative", 1, -5*time.Second)
+	for _, k := range []string{"default", "added", "negative"} {
+		if item := never.itemOf(k); item.Expiration != 0 {
+			t.Fatalf("%s expiration = %d in a cache without default TTL, want none", k, item.Expiration)
+		}
+	}
+
+	timed := NewCache(time.Minute, time.Hour)
+	timed.Set("default", 1, DefaultExpiration)
+	timed.Add("added", 1, DefaultExpiration)
+	timed.Set("never", 1, NoExpiration)
+	timed.Set("explicit", 1, time.Hour)
+	for k, want := range map[string]time.Duration{"default": time.Minute, "added": time.Minute, "explicit": time.Hour} {
+		item := timed.itemOf(k)
+		if left := time.Until(time.Unix(0, item.Expiration)); left > want || left < want-time.Second {
+			t.Fatalf("%s expires in %v, want about %v", k, left, want)
+		}
+	}
+	if item := timed.itemOf("never"); item.Expiration != 0 {
+		t.Fatalf("NoExpiration item has expiration %d", item.Expiration)
+	}
+}
+
+func TestMinWriteInterval(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetMinWriteInterval(30 * time.Millisecond)
+	if err := c.Set("k", 1, DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("k", 2, DefaultExpiration); !errors.Is(err, ErrWriteTooSoon) {
+		t.Fatalf("rapid Set err = %v, want ErrWriteTooSoon", err)
+	}
+	if err := c.Replace("k", 3, DefaultExpiration); !errors.Is(err, ErrWriteTooSoon) {
+		t.Fatalf("rapid Replace err = %v, want ErrWriteTooSoon", err)
+	}
+	if v, _ := c.Get("k"); v != 1 {
+		t.Fatalf("k = %v after skipped overwrites, want 1", v)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := c.Set("k", 4, DefaultExpiration); err != nil {
+		t.Fatalf("spaced Set err = %v", err)
+	}
+	if v, _ := c.Get("k"); v != 4 {
+		t.Fatalf("k = %v, want 4", v)
+	}
+
+	// 续期不算写入，替换值算作一次写入
+	time.Sleep(40 * time.Millisecond)
+	c.GetRefresh("k", time.Hour)
+	c.TouchFunc(time.Hour, func(string, interface{}) bool { return true })
+	if err := c.Set("k", 5, DefaultExpiration); err != nil {
+		t.Fatalf("Set right after GetRefresh err = %v, want nil", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	c.ReplaceKeepTTL("k", 6)
+	if err := c.Set("k", 7, DefaultExpiration); !errors.Is(err, ErrWriteTooSoon) {
+		t.Fatalf("Set right after ReplaceKeepTTL err = %v, want ErrWriteTooSoon", err)
+	}
+}
+
+func TestMinWriteIntervalAllOverwrites(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetMinWriteInterval(time.Hour)
+	c.Set("k", 1, DefaultExpiration)
+	for name, set := range map[string]func() error{
+		"SetWithSize":     func() error { return c.SetWithSize("k", 2, DefaultExpiration, 8) },
+		"SetWithPriority": func() error { return c.SetWithPriority("k", 2, DefaultExpiration, 1) },
+		"SetWithMeta":     func() error { return c.SetWithMeta("k", 2, DefaultExpiration, nil) },
+		"SetRenewable":    func() error { return c.SetRenewable("k", 2, time.Minute, time.Hour) },
+		"ReplaceKeepTTL":  func() error { return c.ReplaceKeepTTL("k", 2) },
+	} {
+		if err := set(); !errors.Is(err, ErrWriteTooSoon) {
+			t.Errorf("%s err = %v, want ErrWriteTooSoon", name, err)
+		}
+	}
+	if old, _ := c.GetSet("k", 2, DefaultExpiration); old != 1 {
+		t.Fatalf("GetSet returned %v, want the current value 1", old)
+	}
+	if c.ReplaceIf("k", func(interface{}) bool { return true }, 2, DefaultExpiration) {
+		t.Fatal("ReplaceIf overwrote a recently written item")
+	}
+	created, overwritten := c.SetManyReport(map[string]interface{}{"k": 2, "new": 3}, DefaultExpiration)
+	if len(overwritten) != 0 || len(created) != 1 || created[0] != "new" {
+		t.Fatalf("SetManyReport = %v, %v, want only new created", created, overwritten)
+	}
+	if v, _ := c.Get("k"); v != 1 {
+		t.Fatalf("k = %v after rejected overwrites, want 1", v)
+	}
+}
+
+func TestWrittenPreservedByLoad(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("k", 1, DefaultExpiration)
+	saved := c.itemOf("k")
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	d := NewCache(time.Minute, time.Hour)
+	if err := d.Load(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if item := d.itemOf("k"); item.Written != saved.Written {
+		t.Fatalf("Written = %d after Load, want the saved %d", item.Written, saved.Written)
+	}
+	d.IncrementChecked("k", 1)
+	if item := d.itemOf("k"); item.Written <= saved.Written {
+		t.Fatal("IncrementChecked did not update Written")
+	}
+}
+
+func TestLoadFromChannel(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("existing", "kept", DefaultExpiration)
+	ch := make(chan Entry)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 300; i++ {
+			exp := time.Now().Add(time.Hour).UnixNano()
+			if i%3 == 0 {
+				exp = time.Now().Add(-time.Second).UnixNano()
+			}
+			ch <- Entry{Key: strconv.Itoa(i), Item: Item{Object: i, Expiration: exp}}
+		}
+		ch <- Entry{Key: "existing", Item: Item{Object: "overwritten"}}
+	}()
+	if n := c.LoadFromChannel(ch); n != 200 {
+		t.Fatalf("LoadFromChannel() = %d, want 200", n)
+	}
+	if _, found := c.Get("0"); found {
+		t.Fatal("expired entry was loaded")
+	}
+	if v, _ := c.Get("1"); v != 1 {
+		t.Fatalf("1 = %v, want 1", v)
+	}
+	if v, _ := c.Get("existing"); v != "kept" {
+		t.Fatalf("existing = %v, want the live value kept", v)
+	}
+}
+
+func TestEvictionCallbackMode(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var order []string
+	c.OnEvicted(func(k string, _ interface{}) {
+		c.Set("seen-"+k, true, DefaultExpiration) // 回调持有锁时会死锁
+		order = append(order, k)
+	})
+	for _, k := range []string{"c", "a", "b"} {
+		c.Set(k, k, time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	if !reflect.DeepEqual(order, []string{"a", "b", "c"}) {
+		t.Fatalf("callbacks ran as %v before DeleteExpired returned, want [a b c]", order)
+	}
+
+	c.SetEvictionCallbackMode(false)
+	var mu sync.Mutex
+	var n int
+	c.OnEvicted(func(string, interface{}) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+	})
+	for i := 0; i < 10; i++ {
+		c.Set(strconv.Itoa(i), i, time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	waitFor(t, time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return n == 10
+	})
+}
+
+func TestGetCopy(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("s", []int{1, 2, 3}, DefaultExpiration)
+	c.Set("m", map[string]int{"a": 1}, DefaultExpiration)
+	c.Set("nested", [][]int{{1}}, DefaultExpiration)
+
+	v, _ := c.GetCopy("s")
+	v.([]int)[0] = 100
+	if stored, _ := c.Get("s"); stored.([]int)[0] != 1 {
+		t.Fatal("mutating the copied slice changed the cached slice")
+	}
+	m, _ := c.GetCopy("m")
+	m.(map[string]int)["a"] = 100
+	m.(map[string]int)["b"] = 2
+	if stored, _ := c.Get("m"); !reflect.DeepEqual(stored, map[string]int{"a": 1}) {
+		t.Fatalf("cached map = %v after mutating the copy", stored)
+	}
+	// 只复制一层，内层切片仍然共享
+	n, _ := c.GetCopy("nested")
+	n.([][]int)[0][0] = 100
+	if stored, _ := c.Get("nested"); stored.([][]int)[0][0] != 100 {
+		t.Fatal("GetCopy copied more than one level")
+	}
+	if _, found := c.GetCopy("missing"); found {
+		t.Fatal("GetCopy found a missing key")
+	}
+}
+
+func TestGetDetailed(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("live", 1, DefaultExpiration)
+	c.Set("stale", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if v, state := c.GetDetailed("live"); state != Live || v != 1 {
+		t.Fatalf("GetDetailed(live) = %v, %v, want 1, Live", v, state)
+	}
+	if v, state := c.GetDetailed("stale"); state != Expired || v != 2 {
+		t.Fatalf("GetDetailed(stale) = %v, %v, want the stale 2, Expired", v, state)
+	}
+	if v, state := c.GetDetailed("absent"); state != Absent || v != nil {
+		t.Fatalf("GetDetailed(absent) = %v, %v, want nil, Absent", v, state)
+	}
+	c.DeleteExpired()
+	if _, state := c.GetDetailed("stale"); state != Absent {
+		t.Fatalf("GetDetailed(stale) after DeleteExpired = %v, want Absent", state)
+	}
+}
+
+func TestFlushCount(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for i := 0; i < 25; i++ {
+		c.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	if n := c.Flush(); n != 25 {
+		t.Fatalf("Flush() = %d, want 25", n)
+	}
+	if n := c.Flush(); n != 0 {
+		t.Fatalf("Flush() on an empty cache = %d, want 0", n)
+	}
+}
+
+func TestLoadWithClockSkew(t *testing.T) {
+	// 保存方的时钟比本机快10分钟
+	const skew = 10 * time.Minute
+	remoteNow := time.Now().Add(skew)
+	dump := map[string]Item{
+		"a":   {Object: 1, Expiration: remoteNow.Add(time.Hour).UnixNano(), Written: remoteNow.UnixNano()},
+		"old": {Object: 2, Expiration: remoteNow.Add(-time.Minute).UnixNano()},
+		"c":   {Object: 3},
+	}
+	var buf bytes.Buffer
+	if err := (gobCodec{}).Encode(&buf, dump); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(NoExpiration, time.Hour)
+	if err := c.LoadWithClockSkew(&buf, -skew); err != nil {
+		t.Fatal(err)
+	}
+	a := c.itemOf("a")
+	if left := time.Until(time.Unix(0, a.Expiration)); left > time.Hour || left < time.Hour-time.Second {
+		t.Fatalf("a expires in %v, want about 1h", left)
+	}
+	if age := time.Since(time.Unix(0, a.Written)); age < 0 || age > time.Second {
+		t.Fatalf("a was written %v ago, want about now", age)
+	}
+	// 按本机时钟还有9分钟，但在保存时已经过期了1分钟
+	if _, found := c.Get("old"); found {
+		t.Fatal("old should have expired after adjusting for the skew")
+	}
+	if item := c.itemOf("c"); item.Expiration != 0 {
+		t.Fatal("no-expiration item was given an expiration")
+	}
+}
+
+type countedEvent struct {
+	Type string
+}
+
+func TestCountBy(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for i, typ := range []string{"click", "view", "click", "buy", "click"} {
+		c.Set(strconv.Itoa(i), countedEvent{typ}, DefaultExpiration)
+	}
+	c.Set("expired", countedEvent{"view"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	got := c.CountBy(func(_ string, v interface{}) string { return v.(countedEvent).Type })
+	want := map[string]int{"click": 3, "view": 1, "buy": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CountBy() = %v, want %v", got, want)
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("k", "v", DefaultExpiration)
+	if v, found, locked := c.TryGet("k"); !locked || !found || v != "v" {
+		t.Fatalf("TryGet() = %v, %v, %v, want v, true, true", v, found, locked)
+	}
+	c.mu.Lock()
+	_, found, locked := c.TryGet("k")
+	c.mu.Unlock()
+	if locked || found {
+		t.Fatalf("TryGet with the write lock held = found %v, locked %v, want false, false", found, locked)
+	}
+	if _, found, locked := c.TryGet("missing"); !locked || found {
+		t.Fatalf("TryGet(missing) = found %v, locked %v, want false, true", found, locked)
+	}
+}
+
+func TestLoadReplace(t *testing.T) {
+	src := NewCache(NoExpiration, time.Hour)
+	src.Set("a", 1, NoExpiration)
+	src.Set("b", 2, NoExpiration)
+	src.Set("gone", 3, time.Millisecond)
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	c := NewCache(NoExpiration, time.Hour)
+	c.Set("a", "current", NoExpiration)
+	c.Set("local", 4, NoExpiration)
+	if err := c.LoadReplace(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := c.Get("local"); found {
+		t.Fatal("key missing from the dump survived LoadReplace")
+	}
+	if v, _ := c.Get("a"); v != 1 {
+		t.Fatalf("a = %v, want the dumped 1", v)
+	}
+	if c.Count() != 2 {
+		t.Fatalf("Count() = %d, want 2 without the expired item", c.Count())
+	}
+	if err := c.LoadReplace(strings.NewReader("garbage")); err == nil {
+		t.Fatal("LoadReplace accepted corrupt data")
+	}
+	if c.Count() != 2 {
+		t.Fatal("failed LoadReplace modified the cache")
+	}
+}
+
+func TestStopGCAfterLoopExited(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.StopGC()
+	waitFor(t, time.Second, func() bool { return !c.Status().GCRunning })
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			c.StopGC()
+		}
+		newCache(time.Minute, time.Hour).StopGC() // 清理协程从未启动
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StopGC blocked after the GC loop exited")
+	}
+}
+
+func TestDebugJSON(t *testing.T) {
+	c := NewCache(NoExpiration, time.Hour)
+	c.Set("n", 42, time.Hour)
+	c.Set("s", "text", NoExpiration)
+	c.Set("fn", func() {}, NoExpiration)
+	data, err := c.DebugJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]struct {
+		Value      interface{} `json:"value"`
+		ExpiresAt  *time.Time  `json:"expiresAt"`
+		TTLSeconds *float64    `json:"ttlSeconds"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("DebugJSON output %s is not valid JSON: %v", data, err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("DebugJSON has %d entries, want 3", len(out))
+	}
+	n := out["n"]
+	if n.Value != 42.0 || n.ExpiresAt == nil || n.TTLSeconds == nil || *n.TTLSeconds > 3600 || *n.TTLSeconds < 3590 {
+		t.Fatalf("n = %+v, want value 42 with a 1h TTL", n)
+	}
+	if s := out["s"]; s.Value != "text" || s.ExpiresAt != nil || s.TTLSeconds != nil {
+		t.Fatalf("s = %+v, want value text with null expiry", s)
+	}
+	if fn := out["fn"]; fn.Value != "func()" {
+		t.Fatalf("fn value = %v, want its type name", fn.Value)
+	}
+}
+
+func TestSetRenewableMaxLifetime(t *testing.T) {
+	start := time.Now()
+	c, now := newFakeClockCache(start)
+	advance := func(d time.Duration) { atomic.AddInt64(now, int64(d)) }
+	for i := 0; i < 5; i++ {
+		c.SetRenewable("k", i, 10*time.Minute, 30*time.Minute)
+		advance(7 * time.Minute)
+		if _, found := c.Get("k"); !found && i < 4 {
+			t.Fatalf("k expired after %v although it was renewed", time.Duration(atomic.LoadInt64(now)-start.UnixNano()))
+		}
+	}
+	item, _ := c.items.get("k")
+	if want := start.Add(30 * time.Minute).UnixNano(); item.Expiration != want {
+		t.Fatalf("expiration = %v, want firstSet+maxLifetime %v", time.Unix(0, item.Expiration), time.Unix(0, want))
+	}
+	if _, found := c.Get("k"); found {
+		t.Fatal("k outlived its max lifetime")
+	}
+
+	// 过期后重新开始计算存活时间
+	c.SetRenewable("k", 0, 10*time.Minute, 30*time.Minute)
+	if item := c.itemOf("k"); item.FirstSet != atomic.LoadInt64(now) {
+		t.Fatal("SetRenewable after expiry did not restart the lifetime")
+	}
+	// 普通的Set会清除续期状态
+	c.Set("k", 1, time.Minute)
+	if item := c.itemOf("k"); item.FirstSet != 0 {
+		t.Fatal("Set kept the renewable state")
+	}
+}
+
+func TestRenewableRefreshCapped(t *testing.T) {
+	start := time.Now()
+	c, now := newFakeClockCache(start)
+	c.SetRenewable("k", 1, 10*time.Minute, 30*time.Minute)
+	c.SetRenewable("other", 2, 10*time.Minute, 30*time.Minute)
+	atomic.AddInt64(now, int64(8*time.Minute))
+	deadline := start.Add(30 * time.Minute).UnixNano()
+
+	if _, found := c.GetRefresh("k", time.Hour); !found {
+		t.Fatal("GetRefresh missed a live renewable item")
+	}
+	if item := c.itemOf("k"); item.Expiration != deadline {
+		t.Fatalf("expiration after GetRefresh = %v, want the max lifetime %v", time.Unix(0, item.Expiration), time.Unix(0, deadline))
+	}
+	c.GetRefresh("k", NoExpiration)
+	if item := c.itemOf("k"); item.Expiration != deadline {
+		t.Fatal("GetRefresh with NoExpiration removed the max lifetime")
+	}
+	c.TouchFunc(time.Hour, func(k string, _ interface{}) bool { return k == "other" })
+	if item := c.itemOf("other"); item.Expiration != deadline {
+		t.Fatalf("expiration after TouchFunc = %v, want the max lifetime %v", time.Unix(0, item.Expiration), time.Unix(0, deadline))
+	}
+	atomic.AddInt64(now, int64(23*time.Minute))
+	if _, found := c.Get("k"); found {
+		t.Fatal("k outlived its max lifetime after GetRefresh")
+	}
+
+	// 续期时间早于最晚过期时间时不受影响
+	c.Set("plain", 3, time.Minute)
+	c.GetRefresh("plain", time.Hour)
+	if item := c.itemOf("plain"); item.Expiration != atomic.LoadInt64(now)+int64(time.Hour) {
+		t.Fatal("GetRefresh capped an item without a max lifetime")
+	}
+}
+
+func TestCopyOnSet(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	shared := map[string][]int{"a": {1}}
+	c.Set("shared", shared, DefaultExpiration)
+	shared["a"][0] = 100
+	if v, _ := c.Get("shared"); v.(map[string][]int)["a"][0] != 100 {
+		t.Fatal("value was copied with copy-on-set off")
+	}
+
+	c.SetCopyOnSet(true)
+	orig := map[string][]int{"a": {1, 2}}
+	c.Set("copied", orig, DefaultExpiration)
+	orig["a"][0] = 100
+	orig["b"] = []int{3}
+	if v, _ := c.Get("copied"); !reflect.DeepEqual(v, map[string][]int{"a": {1, 2}}) {
+		t.Fatalf("cached value = %v after mutating the original", v)
+	}
+	if err := c.Set("func", func() {}, DefaultExpiration); err == nil {
+		t.Fatal("Set accepted a value that cannot be copied")
+	}
+}
+
+func TestCopyOnSetAllWrites(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetCopyOnSet(true)
+	c.Set("existing", []int{0}, DefaultExpiration)
+	writes := map[string]func(k string, v []int){
+		"SetWithSize":     func(k string, v []int) { c.SetWithSize(k, v, DefaultExpiration, 8) },
+		"SetWithPriority": func(k string, v []int) { c.SetWithPriority(k, v, DefaultExpiration, 1) },
+		"SetWithMeta":     func(k string, v []int) { c.SetWithMeta(k, v, DefaultExpiration, nil) },
+		"SetRenewable":    func(k string, v []int) { c.SetRenewable(k, v, time.Minute, time.Hour) },
+		"GetSet":          func(k string, v []int) { c.GetSet(k, v, DefaultExpiration) },
+		"SetManyReport":   func(k string, v []int) { c.SetManyReport(map[string]interface{}{k: v}, DefaultExpiration) },
+		"ReplaceIf": func(k string, v []int) {
+			c.Set(k, nil, DefaultExpiration)
+			c.ReplaceIf(k, func(interface{}) bool { return true }, v, DefaultExpiration)
+		},
+		"ReplaceKeepTTL": func(k string, v []int) {
+			c.Set(k, nil, DefaultExpiration)
+			c.ReplaceKeepTTL(k, v)
+		},
+	}
+	for name, write := range writes {
+		orig := []int{1, 2}
+		write(name, orig)
+		orig[0] = 100
+		if v, _ := c.Get(name); !reflect.DeepEqual(v, []int{1, 2}) {
+			t.Errorf("%s: cached value = %v after mutating the original", name, v)
+		}
+	}
+	if err := c.SetWithSize("func", func() {}, DefaultExpiration, 1); err == nil {
+		t.Fatal("SetWithSize accepted a value that cannot be copied")
+	}
+	if old, _ := c.GetSet("existing", func() {}, DefaultExpiration); !reflect.DeepEqual(old, []int{0}) {
+		t.Fatalf("GetSet returned %v, want the current value", old)
+	}
+	if v, _ := c.Get("existing"); !reflect.DeepEqual(v, []int{0}) {
+		t.Fatalf("GetSet stored a value that cannot be copied: %v", v)
+	}
+	if n := c.IncrOrInit("n", 2, DefaultExpiration); n != 2 {
+		t.Fatalf("IncrOrInit = %d with copy-on-set, want 2", n)
+	}
+}
+
+func TestKeysWithValue(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("b", []int{1}, DefaultExpiration)
+	c.Set("a", []int{1}, DefaultExpiration)
+	c.Set("c", []int{2}, DefaultExpiration)
+	c.Set("d", []int{1}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if keys := c.KeysWithValue([]int{1}); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("KeysWithValue([1]) = %v, want [a b]", keys)
+	}
+	if keys := c.KeysWithValue("none"); len(keys) != 0 {
+		t.Fatalf("KeysWithValue(none) = %v, want empty", keys)
+	}
+}
+
+type versioned struct {
+	Version int
+	Data    string
+}
+
+func TestReplaceIf(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	stale := func(cur interface{}) bool { return cur.(versioned).Version < 2 }
+	c.Set("k", versioned{1, "old"}, DefaultExpiration)
+	if !c.ReplaceIf("k", stale, versioned{2, "new"}, DefaultExpiration) {
+		t.Fatal("ReplaceIf did not replace a stale value")
+	}
+	if c.ReplaceIf("k", stale, versioned{1, "older"}, DefaultExpiration) {
+		t.Fatal("ReplaceIf replaced a value the predicate rejected")
+	}
+	if v, _ := c.Get("k"); v != (versioned{2, "new"}) {
+		t.Fatalf("k = %v, want version 2", v)
+	}
+
+	called := false
+	pred := func(interface{}) bool { called = true; return true }
+	c.Set("gone", versioned{}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if c.ReplaceIf("gone", pred, versioned{}, DefaultExpiration) || c.ReplaceIf("missing", pred, versioned{}, DefaultExpiration) {
+		t.Fatal("ReplaceIf replaced a missing or expired key")
+	}
+	if called {
+		t.Fatal("predicate was called for a missing or expired key")
+	}
+	if _, found := c.Get("missing"); found {
+		t.Fatal("ReplaceIf created a missing key")
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetWithMeta("k", []int{1}, time.Hour, map[string]string{"tag": "a"})
+	stored, _ := c.items.get("k")
+	item, found := c.GetItem("k")
+	if !found || !reflect.DeepEqual(item.Object, []int{1}) || item.Expiration != stored.Expiration || item.Expiration == 0 {
+		t.Fatalf("GetItem = %+v, %v, want [1] expiring at %d", item, found, stored.Expiration)
+	}
+	item.Expiration = 0
+	item.Meta["tag"] = "changed"
+	if again, _ := c.GetItem("k"); again.Expiration == 0 || again.Meta["tag"] != "a" {
+		t.Fatal("mutating the returned Item changed the cached item")
+	}
+	c.Set("forever", 1, NoExpiration)
+	if item, _ := c.GetItem("forever"); item.Object != 1 || item.Expiration != 0 {
+		t.Fatalf("GetItem(forever) = %+v, want 1 with no expiration", item)
+	}
+	c.Set("gone", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, found := c.GetItem("gone"); found {
+		t.Fatal("GetItem returned an expired item")
+	}
+}
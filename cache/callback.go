@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"time"
+)
+
+// 设置缓存数据项，并为它绑定一个过期回调，数据项过期后被清理协程删除
+// 或被Get发现已过期时调用onExpire，每个数据项最多调用一次，回调在锁外执行
+// 数据项的值被替换(包括Set、ReplaceKeepTTL、Increment、MapValues等)、删除或缓存被清空时回调会被取消，
+// 只续期过期时间时回调保留；回调不会随Save保存
+func (c *Cache) SetWithCallback(k string, v interface{}, d time.Duration, onExpire func(k string, v interface{})) error {
+	c.mu.Lock()
+	if err := c.checkKey(k); err != nil {
+		c.unlock()
+		return err
+	}
+	c.set(k, v, d)
+	c.cbMu.Lock()
+	if c.expireCallbacks == nil {
+		c.expireCallbacks = map[string]func(string, interface{}){}
+	}
+	c.expireCallbacks[k] = onExpire
+	c.cbMu.Unlock()
+	c.unlock()
+	return nil
+}
+
+// 取出并移除数据项的过期回调，调用方需要持有c.mu的读锁或写锁
+func (c *Cache) takeExpireCallback(k string) func(string, interface{}) {
+	if c.expireCallbacks == nil {
+		return nil
+	}
+	c.cbMu.Lock()
+	defer c.cbMu.Unlock()
+	f := c.expireCallbacks[k]
+	delete(c.expireCallbacks, k)
+	return f
+}
+
+// 取消数据项的过期回调，调用方需要持有c.mu的写锁
+func (c *Cache) clearExpireCallback(k string) {
+	c.takeExpireCallback(k)
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetWithCallbackFiresOnce(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var fired int32
+	c.SetWithCallback("k", "v", time.Millisecond, func(k string, v interface{}) {
+		if k != "k" || v != "v" {
+			t.Errorf("callback got %q, %v", k, v)
+		}
+		atomic.AddInt32(&fired, 1)
+	})
+	time.Sleep(5 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Get("k")
+		}()
+	}
+	wg.Wait()
+	c.DeleteExpired()
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Fatalf("callback fired %d times, want 1", n)
+	}
+
+	c.SetWithCallback("over", 1, time.Millisecond, func(string, interface{}) {
+		t.Error("callback of an overwritten item fired")
+	})
+	c.Set("over", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+}
+
+func TestExpireCallbackClearedOnValueReplace(t *testing.T) {
+	c, now := newFakeClockCache(time.Unix(1000, 0))
+	fired := map[string]int{}
+	cb := func(k string, _ interface{}) { fired[k]++ }
+	for _, k := range []string{"keepttl", "incr", "mapped"} {
+		c.SetWithCallback(k, 1, time.Minute, cb)
+	}
+	c.ReplaceKeepTTL("keepttl", 2)
+	c.IncrementChecked("incr", 1)
+	c.MapValues(func(k string, v interface{}) interface{} {
+		if k == "mapped" {
+			return 3
+		}
+		return v
+	})
+	// MapValues会替换所有数据项的值，只续期的数据项在它之后写入
+	c.SetWithCallback("refreshed", 1, time.Minute, cb)
+	c.GetRefresh("refreshed", 2*time.Minute)
+	atomic.AddInt64(now, int64(3*time.Minute))
+	c.DeleteExpired()
+	for _, k := range []string{"keepttl", "incr", "mapped"} {
+		if fired[k] != 0 {
+			t.Errorf("callback of %s fired after its value was replaced", k)
+		}
+	}
+	if fired["refreshed"] != 1 {
+		t.Errorf("callback of refreshed fired %d times, want 1", fired["refreshed"])
+	}
+}
+
+func TestOnEvictedBatch(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var batches [][]EvictedItem
+	c.OnEvictedBatch(func(evicted []EvictedItem) {
+		batches = append(batches, evicted)
+	})
+	single := 0
+	c.OnEvicted(func(string, interface{}) { single++ })
+	for _, k := range []string{"a", "b", "c"} {
+		c.Set(k, k+"-v", time.Millisecond)
+	}
+	c.Set("live", 1, time.Hour)
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	if len(batches) != 1 {
+		t.Fatalf("batch callback fired %d times, want 1", len(batches))
+	}
+	got := map[string]interface{}{}
+	for _, e := range batches[0] {
+		got[e.Key] = e.Value
+	}
+	if len(got) != 3 || got["a"] != "a-v" || got["b"] != "b-v" || got["c"] != "c-v" {
+		t.Fatalf("batch = %v, want a, b and c with their values", batches[0])
+	}
+	if single != 3 {
+		t.Fatalf("OnEvicted fired %d times, want 3", single)
+	}
+
+	c.DeleteExpired()
+	if len(batches) != 1 {
+		t.Fatal("batch callback fired for a sweep that removed nothing")
+	}
+}
+
+func TestIsGCRunning(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	if c.IsGCRunning() {
+		t.Fatal("IsGCRunning() = true before any sweep")
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	c.OnEvicted(func(string, interface{}) {
+		close(started)
+		<-release
+	})
+	c.Set("k", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		c.DeleteExpired()
+		close(done)
+	}()
+	<-started
+	if !c.IsGCRunning() {
+		t.Fatal("IsGCRunning() = false while a callback of the sweep is running")
+	}
+	close(release)
+	<-done
+	if c.IsGCRunning() {
+		t.Fatal("IsGCRunning() = true after DeleteExpired returned")
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 设置判断过期时使用的时间精度，d > 0 时由后台协程每隔d刷新一次缓存的当前时间，
+// 过期判断和过期时间的计算都读取这个时间，减少time.Now()的调用，代价是过期判断最多有d的误差
+// d <= 0 时恢复为每次读取精确时间(默认)
+func (c *Cache) SetTimeResolution(d time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.stopClock != nil {
+		close(c.stopClock)
+		c.stopClock = nil
+	}
+	if d <= 0 {
+		atomic.StoreInt32(&c.coarseClock, 0)
+		return
+	}
+	atomic.StoreInt64(&c.coarseNow, time.Now().UnixNano())
+	atomic.StoreInt32(&c.coarseClock, 1)
+	c.stopClock = make(chan struct{})
+	go c.clockLoop(d, c.stopClock)
+}
+
+// 周期性的刷新粗粒度的当前时间，调用StopGC后退出并恢复为读取精确时间
+func (c *Cache) clockLoop(d time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			atomic.StoreInt64(&c.coarseNow, t.UnixNano())
+		case <-stop:
+			return
+		case <-c.done:
+			atomic.StoreInt32(&c.coarseClock, 0)
+			return
+		}
+	}
+}
+
+// 返回当前时间，Unix时间戳，单位是纳秒
+func (c *Cache) now() int64 {
+	if atomic.LoadInt32(&c.coarseClock) == 1 {
+		return atomic.LoadInt64(&c.coarseNow)
+	}
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now().UnixNano()
+}
+
+// 判断数据项是否已经过期，与Item.Expired()相同，但使用缓存配置的时间精度
+func (c *Cache) expired(item Item) bool {
+	if item.Expiration == 0 {
+		return false
+	}
+	return c.now() > item.Expiration
+}
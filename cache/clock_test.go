@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeResolution(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetTimeResolution(time.Hour)
+	frozen := c.now()
+	c.Set("k", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if c.now() != frozen {
+		t.Fatal("coarse clock advanced before its resolution elapsed")
+	}
+	if _, found := c.Get("k"); !found {
+		t.Fatal("item expired although the coarse clock did not advance")
+	}
+
+	c.SetTimeResolution(0)
+	if _, found := c.Get("k"); found {
+		t.Fatal("item did not expire after switching back to exact time")
+	}
+}
+
+func TestClockLoopStopsWithCache(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetTimeResolution(time.Hour)
+	exited := make(chan struct{})
+	go func() {
+		c.clockLoop(time.Hour, make(chan struct{}))
+		close(exited)
+	}()
+	c.StopGC()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("clockLoop did not exit after StopGC")
+	}
+	before := c.now()
+	time.Sleep(time.Millisecond)
+	if c.now() == before {
+		t.Fatal("cache still reads the frozen coarse clock after StopGC")
+	}
+}
+
+func benchmarkGet(b *testing.B, resolution time.Duration) {
+	c := NewCache(time.Minute, time.Hour)
+	defer c.StopGC()
+	c.SetTimeResolution(resolution)
+	c.Set("k", "v", time.Hour)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get("k")
+	}
+}
+
+func BenchmarkGetExactTime(b *testing.B) {
+	benchmarkGet(b, 0)
+}
+
+func BenchmarkGetCoarseTime(b *testing.B) {
+	benchmarkGet(b, 100*time.Millisecond)
+}
+
+// 返回一个时间由测试控制的缓存，不启动清理协程
+func newFakeClockCache(start time.Time) (*Cache, *int64) {
+	now := start.UnixNano()
+	c := newCache(NoExpiration, time.Minute)
+	c.clock = func() int64 { return atomic.LoadInt64(&now) }
+	return c, &now
+}
+
+func TestGCTickAfterTimeJump(t *testing.T) {
+	c, now := newFakeClockCache(time.Now())
+	st := &gcState{interval: time.Minute, last: c.now()}
+	advance := func(d time.Duration) { atomic.AddInt64(now, int64(d)) }
+
+	c.Set("a", 1, 30*time.Second)
+	advance(time.Minute)
+	if ran, reset := c.gcTick(st); !ran || reset {
+		t.Fatalf("regular tick: ran %v, reset %v, want true, false", ran, reset)
+	}
+	if c.Count() != 0 {
+		t.Fatal("expired item was not cleaned")
+	}
+
+	// 休眠10小时后唤醒，积压的tick紧接着到达
+	c.Set("b", 1, time.Hour)
+	advance(10 * time.Hour)
+	if ran, reset := c.gcTick(st); !ran || !reset {
+		t.Fatalf("tick after the jump: ran %v, reset %v, want true, true", ran, reset)
+	}
+	if ran, _ := c.gcTick(st); ran {
+		t.Fatal("backlogged tick ran a second cleanup")
+	}
+	if c.Count() != 0 {
+		t.Fatal("item expired during the jump was not cleaned")
+	}
+	if got := c.Status().LastGCTime; !got.Equal(time.Unix(0, atomic.LoadInt64(now))) {
+		t.Fatalf("LastGCTime = %v, want the fake clock's time", got)
+	}
+	advance(time.Minute)
+	if ran, reset := c.gcTick(st); !ran || reset {
+		t.Fatalf("tick after resuming: ran %v, reset %v, want true, false", ran, reset)
+	}
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// 缓存数据项的序列化接口，Save和Load通过它读写数据项
+type Codec interface {
+	Encode(w io.Writer, items map[string]Item) error
+	Decode(r io.Reader) (map[string]Item, error)
+}
+
+// 默认使用gob序列化数据项
+type gobCodec struct {
+	noRegister bool // 为true时编码前不调用gob.Register，依赖RegisterTypes预先注册
+}
+
+func (gc gobCodec) Encode(w io.Writer, items map[string]Item) (err error) {
+	enc := gob.NewEncoder(w)
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("Error registering from types with Gob library")
+		}
+	}()
+	if !gc.noRegister {
+		for _, v := range items {
+			if _, raw := v.Object.([]byte); raw {
+				continue // gob内置支持[]byte，按长度前缀加字节写入，不需要注册
+			}
+			gob.Register(v.Object)
+		}
+	}
+	err = enc.Encode(&items)
+	return
+}
+
+func (gobCodec) Decode(r io.Reader) (map[string]Item, error) {
+	dec := gob.NewDecoder(r)
+	items := map[string]Item{}
+	err := dec.Decode(&items)
+	return items, err
+}
+
+// Load等方法解码数据失败时返回的错误
+// 数据先被完整解码到临时map中，解码成功后才会写入缓存，所以解码失败时Modified总是false
+type LoadError struct {
+	Decoded  int   // 失败前已经解码出的数据项数量；gob把整个map作为一条消息读取，数据被截断时为0
+	Modified bool  // 缓存是否已被修改
+	Err      error // 解码返回的原始错误
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("Error decoding cache items after %d items: %v", e.Decoded, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// 使用当前的序列化方式解码数据项，失败时返回*LoadError，此时不会修改缓存
+func (c *Cache) decode(r io.Reader) (map[string]Item, error) {
+	c.mu.RLock()
+	codec := c.getCodec()
+	c.mu.RUnlock()
+	items, err := codec.Decode(r)
+	if err != nil {
+		return nil, &LoadError{Decoded: len(items), Err: err}
+	}
+	return items, nil
+}
+
+// 预先向gob注册数据项值的类型，注册后可以通过SetGobAutoRegister(false)
+// 避免每次Save都修改gob的全局注册表
+func RegisterTypes(values ...interface{}) {
+	for _, v := range values {
+		gob.Register(v)
+	}
+}
+
+// 设置使用gob保存时是否为每个数据项的值调用gob.Register，默认开启
+// 关闭后所有值的类型都需要通过RegisterTypes预先注册，否则保存和写预写日志会失败
+func (c *Cache) SetGobAutoRegister(enabled bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.noGobRegister = !enabled
+	if c.wal != nil {
+		c.wal.noRegister = c.noGobRegister
+	}
+}
+
+// 设置Save和Load使用的序列化方式，传入nil时恢复为默认的gob
+func (c *Cache) SetCodec(codec Codec) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.codec = codec
+}
+
+// 返回当前使用的序列化方式，调用方需要持有锁
+func (c *Cache) getCodec() Codec {
+	if c.codec == nil {
+		return gobCodec{noRegister: c.noGobRegister}
+	}
+	return c.codec
+}
+
+// 使用gob将缓存数据项写入到io.Writer中，无法编码的数据项会被跳过而不是使整个保存失败
+// 返回被跳过的key，写入的数据可以正常通过Load读取
+func (c *Cache) SaveSkippingErrors(w io.Writer) (skipped []string, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	items := make(map[string]Item, c.items.len())
+	c.items.rangeItems(func(k string, v Item) bool {
+		if gobEncodable(v, !c.noGobRegister) {
+			items[k] = v
+		} else {
+			skipped = append(skipped, k)
+		}
+		return true
+	})
+	sort.Strings(skipped)
+	return skipped, gobCodec{noRegister: c.noGobRegister}.Encode(w, items)
+}
+
+// 判断数据项能否被gob编码，register为true时先注册值的类型
+func gobEncodable(item Item, register bool) (ok bool) {
+	defer func() {
+		if x := recover(); x != nil {
+			ok = false
+		}
+	}()
+	if register && item.Object != nil {
+		gob.Register(item.Object)
+	}
+	return gob.NewEncoder(io.Discard).Encode(&item) == nil
+}
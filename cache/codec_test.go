@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 只支持字符串值的简单文本编码，每行一个数据项："key\t过期时间\t值"
+type lineCodec struct{}
+
+func (lineCodec) Encode(w io.Writer, items map[string]Item) error {
+	for k, v := range items {
+		if _, err := fmt.Fprintf(w, "%s\t%d\t%s\n", k, v.Expiration, v.Object.(string)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lineCodec) Decode(r io.Reader) (map[string]Item, error) {
+	items := map[string]Item{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.SplitN(sc.Text(), "\t", 3)
+		if len(f) != 3 {
+			return items, fmt.Errorf("bad line %q", sc.Text())
+		}
+		exp, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			return items, err
+		}
+		items[f[0]] = Item{Object: f[2], Expiration: exp}
+	}
+	return items, sc.Err()
+}
+
+func TestCustomCodecRoundTrip(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetCodec(lineCodec{})
+	c.Set("a", "alpha", time.Hour)
+	c.Set("b", "beta", NoExpiration)
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "a\t") {
+		t.Fatalf("Save did not use the custom codec: %q", buf.String())
+	}
+
+	d := NewCache(time.Minute, time.Hour)
+	d.SetCodec(lineCodec{})
+	if err := d.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"a": "alpha", "b": "beta"} {
+		if v, found := d.Get(k); !found || v != want {
+			t.Fatalf("Get(%q) = %v, %v, want %q", k, v, found, want)
+		}
+	}
+	ea, eb := d.itemOf("a"), d.itemOf("b")
+	if ea.Expiration != c.itemOf("a").Expiration || eb.Expiration != 0 {
+		t.Fatal("expirations were not round-tripped")
+	}
+}
+
+func TestSaveSkippingErrors(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("a", "alpha", NoExpiration)
+	c.Set("n", 42, NoExpiration)
+	c.Set("ch", make(chan int), NoExpiration)
+	c.Set("fn", func() {}, NoExpiration)
+	var buf bytes.Buffer
+	skipped, err := c.SaveSkippingErrors(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skipped, []string{"ch", "fn"}) {
+		t.Fatalf("skipped = %v, want [ch fn]", skipped)
+	}
+	d := NewCache(time.Minute, time.Hour)
+	if err := d.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.Get("a"); v != "alpha" {
+		t.Fatalf("a = %v, want alpha", v)
+	}
+	if v, _ := d.Get("n"); v != 42 {
+		t.Fatalf("n = %v, want 42", v)
+	}
+	if d.Count() != 2 {
+		t.Fatalf("Count() = %d after load, want 2", d.Count())
+	}
+}
+
+type registeredPoint struct {
+	X, Y int
+}
+
+func TestLoadTruncatedData(t *testing.T) {
+	src := NewCache(time.Minute, time.Hour)
+	for i := 0; i < 10; i++ {
+		src.Set(strconv.Itoa(i), i, NoExpiration)
+	}
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("keep", "v", NoExpiration)
+	err := c.Load(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+	var le *LoadError
+	if !errors.As(err, &le) {
+		t.Fatalf("Load of truncated data = %v (%T), want *LoadError", err, err)
+	}
+	if le.Modified {
+		t.Fatal("LoadError reports that the cache was modified")
+	}
+	if c.Count() != 1 {
+		t.Fatalf("Count() = %d after a failed Load, want 1", c.Count())
+	}
+	if v, _ := c.Get("keep"); v != "v" {
+		t.Fatalf("keep = %v after a failed Load, want v", v)
+	}
+}
+
+func TestRegisterTypes(t *testing.T) {
+	RegisterTypes(registeredPoint{})
+	c := NewCache(time.Minute, time.Hour)
+	c.SetGobAutoRegister(false)
+	c.Set("p", registeredPoint{1, 2}, NoExpiration)
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	d := NewCache(time.Minute, time.Hour)
+	if err := d.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.Get("p"); v != (registeredPoint{1, 2}) {
+		t.Fatalf("p = %v, want {1 2}", v)
+	}
+
+	type unregistered struct{ A int }
+	c.Set("u", unregistered{1}, NoExpiration)
+	if err := c.Save(io.Discard); err == nil {
+		t.Fatal("Save succeeded with an unregistered type and auto-register off")
+	}
+}
+
+func TestSetRawRoundTrip(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetGobAutoRegister(false)
+	body := []byte("HTTP/1.1 200 OK\r\n\r\n\x00\xff")
+	c.SetRaw("resp", body, NoExpiration)
+	c.Set("name", "alice", NoExpiration)
+	c.Set("n", 42, NoExpiration)
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	d := NewCache(time.Minute, time.Hour)
+	if err := d.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := d.GetRaw("resp"); !ok || !bytes.Equal(b, body) {
+		t.Fatalf("GetRaw(resp) = %q, %v, want %q", b, ok, body)
+	}
+	if v, _ := d.Get("name"); v != "alice" {
+		t.Fatalf("name = %v, want alice", v)
+	}
+	if v, _ := d.Get("n"); v != 42 {
+		t.Fatalf("n = %v, want 42", v)
+	}
+	if _, ok := d.GetRaw("name"); ok {
+		t.Fatal("GetRaw returned a non-byte value")
+	}
+}
+
+func TestItemMetaRoundTrip(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	meta := map[string]string{"source": "db", "version": "3"}
+	if err := c.SetWithMeta("k", "v", DefaultExpiration, meta); err != nil {
+		t.Fatal(err)
+	}
+	meta["version"] = "4"
+	got, found := c.GetMeta("k")
+	if !found || !reflect.DeepEqual(got, map[string]string{"source": "db", "version": "3"}) {
+		t.Fatalf("GetMeta = %v, %v, want the metadata as set", got, found)
+	}
+	got["source"] = "changed"
+	if again, _ := c.GetMeta("k"); again["source"] != "db" {
+		t.Fatal("mutating the returned metadata changed the cached metadata")
+	}
+
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewCache(time.Minute, time.Hour)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loaded.GetMeta("k"); !reflect.DeepEqual(got, map[string]string{"source": "db", "version": "3"}) {
+		t.Fatalf("metadata after Save/Load = %v", got)
+	}
+	if _, found := loaded.GetMeta("missing"); found {
+		t.Fatal("GetMeta found a missing key")
+	}
+	loaded.Set("k", "w", DefaultExpiration)
+	if got, found := loaded.GetMeta("k"); !found || got != nil {
+		t.Fatalf("GetMeta after Set = %v, %v, want nil, true", got, found)
+	}
+}
+
+func TestSaveIndexRoundTrip(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("forever", strings.Repeat("large value ", 1000), NoExpiration)
+	c.Set("hour", "secret-value", time.Hour)
+	c.Set("gone", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	var buf bytes.Buffer
+	if err := c.SaveIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret-value")) || buf.Len() > 1000 {
+		t.Fatalf("index of %d bytes contains values", buf.Len())
+	}
+	index, err := LoadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour, _ := c.items.get("hour")
+	if want := map[string]int64{"forever": 0, "hour": hour.Expiration}; !reflect.DeepEqual(index, want) {
+		t.Fatalf("LoadIndex = %v, want %v", index, want)
+	}
+	if _, err := LoadIndex(strings.NewReader("garbage")); err == nil {
+		t.Fatal("LoadIndex accepted garbage")
+	}
+}
@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// 整数加减运算溢出时返回的错误
+var ErrOverflow = errors.New("integer overflow")
+
+// 将数据项的整数值加上n并返回新值，数据项不存在或已过期时返回错误
+// 如果结果超出值本身类型的范围，返回ErrOverflow，且不修改存储的值
+func (c *Cache) IncrementChecked(k string, n int64) (int64, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
+		return 0, fmt.Errorf("%w: %s", ErrKeyNotFound, k)
+	}
+	cur, ok := c.toInt64(item.Object)
+	if !ok {
+		return 0, fmt.Errorf("The value for %s is not an integer", k)
+	}
+	r, ok := addInt64(cur, n)
+	if !ok {
+		return cur, ErrOverflow
+	}
+	v, ok := fromInt64(item.Object, r)
+	if !ok {
+		return cur, ErrOverflow
+	}
+	item.Object = v
+	c.setItem(k, item)
+	return r, nil
+}
+
+// 将数据项的浮点数值加上n并写回缓存，返回新值以及新值向下取整后的整数
+// 数据项不存在、已过期或不是float32/float64时返回错误
+func (c *Cache) IncrementFloatAndFloor(k string, n float64) (stored float64, floored int64, err error) {
+	c.mu.Lock()
+	defer c.unlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
+		return 0, 0, fmt.Errorf("%w: %s", ErrKeyNotFound, k)
+	}
+	switch v := item.Object.(type) {
+	case float32:
+		v += float32(n)
+		item.Object = v
+		stored = float64(v)
+	case float64:
+		v += n
+		item.Object = v
+		stored = v
+	default:
+		return 0, 0, fmt.Errorf("The value for %s is not a float", k)
+	}
+	c.setItem(k, item)
+	return stored, int64(math.Floor(stored)), nil
+}
+
+// 将数据项的整数值加上n，并以过期时间d写回缓存，返回新值
+// 数据项不存在、已过期或不是整数时按0处理，不会返回错误
+// 结果超出值本身类型的范围时截断为该类型的最大值或最小值，存储的类型保持不变；key不合法时不写入并返回0
+func (c *Cache) IncrOrInit(k string, n int64, d time.Duration) int64 {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.checkKey(k) != nil {
+		return 0
+	}
+	var v interface{} = n
+	if item, found := c.items.get(k); found && !c.expired(item) {
+		if cur, ok := c.toInt64(item.Object); ok {
+			v, n = saturatingAdd(item.Object, cur, n)
+		}
+	}
+	if cp, err := c.copyOnSetValue(v); err == nil {
+		v = cp
+	}
+	c.set(k, v, d)
+	return n
+}
+
+// 当加上n之后的结果不超过ceiling时，将数据项的整数值加上n并返回新值和true
+// 否则不修改数据项，返回当前值和false；数据项不存在或已过期时从0开始并以过期时间d写入，
+// 已存在的数据项保持原来的过期时间。值不是整数、结果溢出或需要新建的key不合法时返回错误
+func (c *Cache) IncrementIfBelow(k string, n, ceiling int64, d time.Duration) (int64, bool, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	item, found := c.items.get(k)
+	if !found || c.expired(item) {
+		if n > ceiling {
+			return 0, false, nil
+		}
+		if err := c.checkKey(k); err != nil {
+			return 0, false, err
+		}
+		c.set(k, n, d)
+		return n, true, nil
+	}
+	cur, ok := c.toInt64(item.Object)
+	if !ok {
+		return 0, false, fmt.Errorf("The value for %s is not an integer", k)
+	}
+	r, ok := addInt64(cur, n)
+	if !ok {
+		return cur, false, ErrOverflow
+	}
+	if r > ceiling {
+		return cur, false, nil
+	}
+	v, ok := fromInt64(item.Object, r)
+	if !ok {
+		return cur, false, ErrOverflow
+	}
+	item.Object = v
+	c.setItem(k, item)
+	return r, true, nil
+}
+
+// 滑动窗口计数：记录一次对k的访问，返回最近window时间内(包括本次)的访问次数
+// 访问时间以[]int64(Unix纳秒时间戳)的形式存在缓存中，过期时间为window，超出窗口的时间戳在每次调用时被清理
+// 数据项已存在但不是由Hit写入时会被覆盖；key不合法或window <= 0时不记录并返回0
+func (c *Cache) Hit(k string, window time.Duration) int {
+	if window <= 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if c.checkKey(k) != nil {
+		return 0
+	}
+	now := c.now()
+	cutoff := now - int64(window)
+	var hits []int64
+	if item, found := c.items.get(k); found && !c.expired(item) {
+		if old, ok := item.Object.([]int64); ok {
+			// 不在原切片上修改，Get返回的切片可能正在被其他协程读取
+			hits = make([]int64, 0, len(old)+1)
+			for _, t := range old {
+				if t > cutoff {
+					hits = append(hits, t)
+				}
+			}
+		}
+	}
+	hits = append(hits, now)
+	c.set(k, hits, window)
+	return len(hits)
+}
+
+// 绑定到一个key上的计数器，所有操作都是原子的
+type Counter struct {
+	c *Cache
+	k string
+	d time.Duration // 每次修改后计数器的过期时间
+}
+
+// 返回绑定到key k的计数器，每次修改计数器都会以过期时间d写回缓存
+func (c *Cache) Counter(k string, d time.Duration) *Counter {
+	return &Counter{c: c, k: k, d: d}
+}
+
+// 计数器加一，返回新值
+func (ct *Counter) Inc() int64 {
+	return ct.Add(1)
+}
+
+// 计数器加上n，返回新值，计数器不存在或已过期时从0开始，溢出时截断为最大值或最小值
+func (ct *Counter) Add(n int64) int64 {
+	return ct.c.IncrOrInit(ct.k, n, ct.d)
+}
+
+// 返回计数器的当前值，计数器不存在、已过期或不是整数时返回0
+func (ct *Counter) Value() int64 {
+	ct.c.mu.RLock()
+	defer ct.c.mu.RUnlock()
+	v, found := ct.c.get(ct.k)
+	if !found {
+		return 0
+	}
+	n, _ := ct.c.toInt64(v)
+	return n
+}
+
+// 将计数器重置为0
+func (ct *Counter) Reset() {
+	ct.c.Set(ct.k, int64(0), ct.d)
+}
+
+// 设置整数加减操作是否尝试将字符串形式的数字(如"5")解析为整数，默认关闭
+// 开启后字符串值加减的结果仍以字符串形式存储
+func (c *Cache) SetNumericCoercion(enabled bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.numericCoercion = enabled
+}
+
+// 将数据项的值转换为int64，开启数字转换时也接受字符串形式的整数，调用方需要持有锁
+func (c *Cache) toInt64(v interface{}) (int64, bool) {
+	if s, ok := v.(string); ok && c.numericCoercion {
+		n, err := strconv.ParseInt(s, 10, 64)
+		return n, err == nil
+	}
+	return toInt64(v)
+}
+
+// 将有符号整数类型的值转换为int64
+func toInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	}
+	return 0, false
+}
+
+// 将n转换回与orig相同的类型，超出该类型范围时返回false
+func fromInt64(orig interface{}, n int64) (interface{}, bool) {
+	switch orig.(type) {
+	case int:
+		if n < math.MinInt || n > math.MaxInt {
+			return nil, false
+		}
+		return int(n), true
+	case int8:
+		if n < math.MinInt8 || n > math.MaxInt8 {
+			return nil, false
+		}
+		return int8(n), true
+	case int16:
+		if n < math.MinInt16 || n > math.MaxInt16 {
+			return nil, false
+		}
+		return int16(n), true
+	case int32:
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, false
+		}
+		return int32(n), true
+	case string:
+		return strconv.FormatInt(n, 10), true
+	}
+	return n, true
+}
+
+// 返回与v相同的整数类型的取值范围，其他类型按int64处理
+func intRange(v interface{}) (min, max int64) {
+	switch v.(type) {
+	case int:
+		return math.MinInt, math.MaxInt
+	case int8:
+		return math.MinInt8, math.MaxInt8
+	case int16:
+		return math.MinInt16, math.MaxInt16
+	case int32:
+		return math.MinInt32, math.MaxInt32
+	}
+	return math.MinInt64, math.MaxInt64
+}
+
+// 计算cur+n，结果超出orig类型的范围时截断为该类型的最大值或最小值
+// 返回与orig同类型的结果以及对应的int64值
+func saturatingAdd(orig interface{}, cur, n int64) (interface{}, int64) {
+	min, max := intRange(orig)
+	r, ok := addInt64(cur, n)
+	switch {
+	case !ok && n > 0, ok && r > max:
+		r = max
+	case !ok, r < min:
+		r = min
+	}
+	v, _ := fromInt64(orig, r)
+	return v, r
+}
+
+// int64加法，发生上溢或下溢时返回false
+func addInt64(a, b int64) (int64, bool) {
+	r := a + b
+	if (b > 0 && r < a) || (b < 0 && r > a) {
+		return 0, false
+	}
+	return r, true
+}
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIncrementCheckedOverflow(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("n", int64(math.MaxInt64-1), 0)
+	if v, err := c.IncrementChecked("n", 1); err != nil || v != math.MaxInt64 {
+		t.Fatalf("IncrementChecked = %d, %v, want MaxInt64", v, err)
+	}
+	if _, err := c.IncrementChecked("n", 1); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("err = %v, want ErrOverflow", err)
+	}
+	if v, _ := c.Get("n"); v != int64(math.MaxInt64) {
+		t.Fatalf("stored value = %v after overflow, want unchanged MaxInt64", v)
+	}
+
+	c.Set("m", int64(math.MinInt64), 0)
+	if _, err := c.IncrementChecked("m", -1); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("underflow err = %v, want ErrOverflow", err)
+	}
+
+	c.Set("small", int8(127), 0)
+	if _, err := c.IncrementChecked("small", 1); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("int8 err = %v, want ErrOverflow", err)
+	}
+	if v, _ := c.Get("small"); v != int8(127) {
+		t.Fatalf("int8 value = %v (%T), want unchanged int8(127)", v, v)
+	}
+
+	if _, err := c.IncrementChecked("missing", 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("missing key err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestIncrOrInit(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	if v := c.IncrOrInit("new", 5, time.Hour); v != 5 {
+		t.Fatalf("IncrOrInit on a missing key = %d, want 5", v)
+	}
+	if v := c.IncrOrInit("new", -2, time.Hour); v != 3 {
+		t.Fatalf("IncrOrInit = %d, want 3", v)
+	}
+
+	c.Set("max", int64(math.MaxInt64), 0)
+	if v := c.IncrOrInit("max", 1, 0); v != math.MaxInt64 {
+		t.Fatalf("IncrOrInit(MaxInt64+1) = %d, want saturated MaxInt64", v)
+	}
+	c.Set("min", int64(math.MinInt64), 0)
+	if v := c.IncrOrInit("min", -1, 0); v != math.MinInt64 {
+		t.Fatalf("IncrOrInit(MinInt64-1) = %d, want saturated MinInt64", v)
+	}
+
+	c.Set("small", int8(127), 0)
+	if v := c.IncrOrInit("small", 1, 0); v != 127 {
+		t.Fatalf("IncrOrInit(int8(127)+1) = %d, want 127", v)
+	}
+	if v, _ := c.Get("small"); v != int8(127) {
+		t.Fatalf("stored %v (%T), want int8(127)", v, v)
+	}
+}
+
+func TestNumericCoercion(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("n", "5", DefaultExpiration)
+	if _, err := c.IncrementChecked("n", 1); err == nil {
+		t.Fatal("IncrementChecked accepted a string value with coercion off")
+	}
+	c.SetNumericCoercion(true)
+	if v, err := c.IncrementChecked("n", 1); err != nil || v != 6 {
+		t.Fatalf("IncrementChecked = %d, %v, want 6", v, err)
+	}
+	if v, _ := c.Get("n"); v != "6" {
+		t.Fatalf("stored %v (%T), want \"6\"", v, v)
+	}
+	c.Set("bad", "five", DefaultExpiration)
+	if _, err := c.IncrementChecked("bad", 1); err == nil {
+		t.Fatal("IncrementChecked accepted a non-numeric string")
+	}
+}
+
+func TestCounterConcurrent(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	ct := c.Counter("hits", time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ct.Inc()
+			}
+			ct.Add(5)
+		}()
+	}
+	wg.Wait()
+	if v := ct.Value(); v != 20*105 {
+		t.Fatalf("Value() = %d, want %d", v, 20*105)
+	}
+	if v := c.Counter("hits", time.Hour).Value(); v != 20*105 {
+		t.Fatalf("second Counter for the same key = %d, want %d", v, 20*105)
+	}
+	ct.Reset()
+	if v := ct.Value(); v != 0 {
+		t.Fatalf("Value() after Reset = %d, want 0", v)
+	}
+}
+
+func TestIncrementFloatAndFloor(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("f", 0.0, DefaultExpiration)
+	var stored float64
+	var floored int64
+	for i := 0; i < 5; i++ {
+		var err error
+		if stored, floored, err = c.IncrementFloatAndFloor("f", 0.25); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stored != 1.25 || floored != 1 {
+		t.Fatalf("after 5 x 0.25: stored %v, floored %d, want 1.25, 1", stored, floored)
+	}
+	if _, floored, _ = c.IncrementFloatAndFloor("f", -1.5); floored != -1 {
+		t.Fatalf("floor(-0.25) = %d, want -1", floored)
+	}
+	c.Set("f32", float32(1.5), DefaultExpiration)
+	if _, floored, err := c.IncrementFloatAndFloor("f32", 1); err != nil || floored != 2 {
+		t.Fatalf("float32 floored = %d, %v, want 2", floored, err)
+	}
+	if v, _ := c.Get("f32"); v != float32(2.5) {
+		t.Fatalf("stored %v (%T), want float32(2.5)", v, v)
+	}
+	c.Set("i", 1, DefaultExpiration)
+	if _, _, err := c.IncrementFloatAndFloor("i", 1); err == nil {
+		t.Fatal("IncrementFloatAndFloor accepted an int value")
+	}
+}
+
+func TestIncrementIfBelow(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var v int64
+	var ok bool
+	var err error
+	for i := 0; i < 5; i++ {
+		if v, ok, err = c.IncrementIfBelow("n", 2, 7, DefaultExpiration); err != nil {
+			t.Fatal(err)
+		}
+		if want := i < 3; ok != want {
+			t.Fatalf("increment %d: ok = %v, want %v (value %d)", i, ok, want, v)
+		}
+		if v > 7 {
+			t.Fatalf("value %d exceeds the ceiling", v)
+		}
+	}
+	if got, _ := c.Get("n"); got != int64(6) {
+		t.Fatalf("n = %v, want 6", got)
+	}
+	if v, ok, _ := c.IncrementIfBelow("fresh", 10, 5, DefaultExpiration); ok || v != 0 {
+		t.Fatalf("IncrementIfBelow over the ceiling on a missing key = %d, %v, want 0, false", v, ok)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := c.IncrementIfBelow("shared", 1, 20, DefaultExpiration); ok {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 20 {
+		t.Fatalf("%d concurrent increments succeeded, want 20", succeeded)
+	}
+}
+
+func TestHitWindowDecay(t *testing.T) {
+	c, now := newFakeClockCache(time.Now())
+	advance := func(d time.Duration) { atomic.AddInt64(now, int64(d)) }
+	for i := 1; i <= 3; i++ {
+		if n := c.Hit("k", time.Minute); n != i {
+			t.Fatalf("hit %d counted %d", i, n)
+		}
+		advance(20 * time.Second)
+	}
+	// 第一次访问已经超出窗口
+	if n := c.Hit("k", time.Minute); n != 3 {
+		t.Fatalf("Hit after 60s = %d, want 3", n)
+	}
+	advance(2 * time.Minute)
+	if _, found := c.Get("k"); found {
+		t.Fatal("hit record outlived its window")
+	}
+	if n := c.Hit("k", time.Minute); n != 1 {
+		t.Fatalf("Hit after the window passed = %d, want 1", n)
+	}
+	for _, w := range []time.Duration{0, -time.Minute} {
+		if n := c.Hit("zero", w); n != 0 {
+			t.Fatalf("Hit with window %v = %d, want 0", w, n)
+		}
+	}
+	if _, found := c.Get("zero"); found {
+		t.Fatal("Hit with a non-positive window stored a record")
+	}
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// 设置死信缓存，DeleteExpired删除过期数据项时把它的副本以ttl的过期时间写入dl，便于事后排查
+// 死信缓存自身过期的数据项不会再转入其他死信缓存，避免递归；dl为nil表示关闭
+// 更换或关闭死信缓存后，原来的死信缓存如果不再被其他缓存使用，会恢复为普通缓存
+func (c *Cache) SetDeadLetterCache(dl *Cache, ttl time.Duration) error {
+	if dl == c {
+		return errors.New("Dead letter cache must differ from the cache itself")
+	}
+	c.mu.Lock()
+	old := c.deadLetter
+	c.deadLetter, c.deadLetterTTL = dl, ttl
+	c.unlock()
+	// 在c.mu之外修改两个死信缓存的引用计数，避免锁嵌套
+	if dl != nil {
+		dl.mu.Lock()
+		dl.deadLetterSink++
+		dl.unlock()
+	}
+	if old != nil {
+		old.mu.Lock()
+		old.deadLetterSink--
+		old.unlock()
+	}
+	return nil
+}
+
+// 返回本次清理应写入的死信缓存，没有或自身就是死信缓存时返回nil，调用方需要持有锁
+func (c *Cache) deadLetterTarget() *Cache {
+	if c.deadLetterSink > 0 {
+		return nil
+	}
+	return c.deadLetter
+}
+
+// 把过期数据项写入死信缓存，在c.mu之外调用，避免两个缓存的锁嵌套
+func (dl *Cache) putDeadLetters(items []keyAndValue, ttl time.Duration) {
+	if len(items) == 0 {
+		return
+	}
+	dl.mu.Lock()
+	for _, kv := range items {
+		dl.set(kv.key, kv.value, ttl)
+	}
+	dl.unlock()
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadLetterCache(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	dl := NewCache(time.Minute, time.Hour)
+	other := NewCache(time.Minute, time.Hour)
+	if err := c.SetDeadLetterCache(c, time.Hour); err == nil {
+		t.Fatal("a cache was accepted as its own dead letter cache")
+	}
+	if err := c.SetDeadLetterCache(dl, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	dl.SetDeadLetterCache(other, time.Hour)
+	c.Set("k", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	if v, found := dl.Get("k"); !found || v != "v" {
+		t.Fatalf("dead letter k = %v, %v, want v, true", v, found)
+	}
+
+	// 死信缓存自身过期的数据项不会继续转移
+	dl.Set("own", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	dl.DeleteExpired()
+	if _, found := other.Get("own"); found {
+		t.Fatal("item expired in a dead letter cache was dead-lettered again")
+	}
+
+	// 不再被使用的死信缓存恢复为普通缓存
+	c.SetDeadLetterCache(nil, 0)
+	dl.Set("own", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	dl.DeleteExpired()
+	if v, found := other.Get("own"); !found || v != 2 {
+		t.Fatalf("other own = %v, %v after dl stopped being a dead letter cache, want 2, true", v, found)
+	}
+}
+
+func TestDeadLetterCacheSharedTarget(t *testing.T) {
+	a := NewCache(time.Minute, time.Hour)
+	b := NewCache(time.Minute, time.Hour)
+	dl := NewCache(time.Minute, time.Hour)
+	a.SetDeadLetterCache(dl, time.Hour)
+	b.SetDeadLetterCache(dl, time.Hour)
+	a.SetDeadLetterCache(NewCache(time.Minute, time.Hour), time.Hour)
+	if dl.deadLetterSink != 1 {
+		t.Fatalf("dl is used by b but deadLetterSink = %d", dl.deadLetterSink)
+	}
+}
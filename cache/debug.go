@@ -0,0 +1,65 @@
+package cache
+
+import "fmt"
+
+// 设置是否在每次修改数据项后检查内部数据结构的一致性，默认关闭，用于开发调试
+// 开启后每次写入和删除都会遍历全部数据项，发现不一致时panic；关闭时只多一次布尔判断
+// 检查的内容：读取时间、过期回调和二级索引中的key都必须存在于数据项中，二级索引与数据项的值一致
+func (c *Cache) SetDebugChecks(enabled bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.debugChecks = enabled
+	if enabled {
+		c.checkInvariants()
+	}
+}
+
+// 检查内部数据结构的一致性，不一致时panic，调用方需要持有写锁
+func (c *Cache) checkInvariants() {
+	if c.accessed != nil {
+		c.accessMu.Lock()
+		for k := range c.accessed {
+			if _, found := c.items.get(k); !found {
+				c.accessMu.Unlock()
+				panic(fmt.Sprintf("cache: access time recorded for missing key %q", k))
+			}
+		}
+		c.accessMu.Unlock()
+	}
+	if c.expireCallbacks != nil {
+		c.cbMu.Lock()
+		for k := range c.expireCallbacks {
+			if _, found := c.items.get(k); !found {
+				c.cbMu.Unlock()
+				panic(fmt.Sprintf("cache: expire callback registered for missing key %q", k))
+			}
+		}
+		c.cbMu.Unlock()
+	}
+	for name, idx := range c.indexes {
+		n := 0
+		for _, ks := range idx.values {
+			n += len(ks)
+		}
+		if n != len(idx.keys) {
+			panic(fmt.Sprintf("cache: index %q has %d values for %d keys", name, n, len(idx.keys)))
+		}
+		for k, iv := range idx.keys {
+			if _, found := idx.values[iv][k]; !found {
+				panic(fmt.Sprintf("cache: index %q maps key %q to %q but the value set lacks it", name, k, iv))
+			}
+		}
+		c.items.rangeItems(func(k string, item Item) bool {
+			iv, ok := idx.extract(item.Object)
+			if got, found := idx.keys[k]; ok != found || (ok && got != iv) {
+				panic(fmt.Sprintf("cache: index %q is stale for key %q", name, k))
+			}
+			return true
+		})
+		for k := range idx.keys {
+			if _, found := c.items.get(k); !found {
+				panic(fmt.Sprintf("cache: index %q contains missing key %q", name, k))
+			}
+		}
+	}
+}
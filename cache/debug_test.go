@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 执行f并返回其panic的信息，没有panic时返回空字符串
+func panicMessage(f func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	f()
+	return ""
+}
+
+func TestDebugChecksDetectCorruption(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetTrackAccess(true)
+	c.Set("a", 1, DefaultExpiration)
+	c.accessed["ghost"] = 1
+	if msg := panicMessage(func() { c.Set("b", 2, DefaultExpiration) }); msg != "" {
+		t.Fatalf("Set panicked with debug checks off: %s", msg)
+	}
+	if msg := panicMessage(func() { c.SetDebugChecks(true) }); !strings.Contains(msg, `missing key "ghost"`) {
+		t.Fatalf("enabling checks on corrupt state panicked with %q", msg)
+	}
+	delete(c.accessed, "ghost")
+	if msg := panicMessage(func() { c.Set("c", 3, DefaultExpiration) }); msg != "" {
+		t.Fatalf("Set panicked on consistent state: %s", msg)
+	}
+
+	c.AddIndex("parity", func(v interface{}) (string, bool) {
+		return fmt.Sprint(v.(int) % 2), true
+	})
+	delete(c.indexes["parity"].keys, "a")
+	if msg := panicMessage(func() { c.Delete("b") }); !strings.Contains(msg, `index "parity"`) {
+		t.Fatalf("Delete after corrupting the index panicked with %q", msg)
+	}
+}
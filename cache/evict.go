@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"sort"
+)
+
+// 按照过期时间从近到远删除一定比例的未过期数据项，返回删除的数量
+// 永不过期的数据项排在最后，fraction的取值范围为[0, 1]，NaN按0处理
+func (c *Cache) EvictFraction(fraction float64) int {
+	if !(fraction > 0) {
+		return 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	var evictedItems []keyAndValue
+	c.mu.Lock()
+	keys := c.keysByExpiration()
+	n := int(float64(len(keys)) * fraction)
+	for _, k := range keys[:n] {
+		if v, evicted := c.delete(k); evicted {
+			evictedItems = append(evictedItems, keyAndValue{k, v})
+		}
+	}
+	notify := c.evictedNotifier(evictedItems)
+	c.unlock()
+	notify()
+	return n
+}
+
+// 删除一个最先过期的未过期数据项，没有设置过期时间的数据项最后才会被选中
+// 开启了读取时间记录(SetTrackAccess)时，删除最久未被读取的数据项
+// 优先级(SetWithPriority)低的数据项总是先于优先级高的被删除
+// 返回被删除的key，缓存为空时evicted为false
+func (c *Cache) EvictOldest() (key string, evicted bool) {
+	c.mu.Lock()
+	key, evicted = c.oldestKey()
+	var v interface{}
+	var fire bool
+	if evicted {
+		v, fire = c.delete(key)
+	}
+	onEvicted := c.onEvicted
+	c.unlock()
+	if fire {
+		onEvicted(key, v)
+	}
+	return key, evicted
+}
+
+// 返回下一个应该被淘汰的未过期数据项的key，调用方需要持有锁
+// 先选择优先级最低的数据项，优先级相同时，开启读取时间记录则选择最久未被读取的，否则选择最先过期的
+func (c *Cache) oldestKey() (string, bool) {
+	if c.trackAccess {
+		return c.leastRecentlyUsedKey()
+	}
+	var (
+		key   string
+		exp   int64
+		prio  int
+		found bool
+	)
+	c.items.rangeItems(func(k string, v Item) bool {
+		if c.expired(v) {
+			return true
+		}
+		if !found || v.Priority < prio ||
+			(v.Priority == prio && v.Expiration > 0 && (exp == 0 || v.Expiration < exp)) {
+			key, exp, prio, found = k, v.Expiration, v.Priority, true
+		}
+		return true
+	})
+	return key, found
+}
+
+// 在一次加锁中删除并返回最多n个最先过期的未过期数据项，永不过期的数据项排在最后
+// 与DeleteExpired不同，这里删除的是尚未过期的数据项，不会调用OnEvicted回调
+func (c *Cache) PopExpiringBatch(n int) map[string]interface{} {
+	if n <= 0 {
+		return map[string]interface{}{}
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	keys := c.keysByExpiration()
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	items := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		items[k] = c.itemOf(k).Object
+		c.delete(k)
+	}
+	return items
+}
+
+// 返回所有未过期数据项的key，按过期时间从近到远排序，永不过期的排在最后
+func (c *Cache) keysByExpiration() []string {
+	keys := make([]string, 0, c.items.len())
+	c.items.rangeItems(func(k string, v Item) bool {
+		if !c.expired(v) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		ei, ej := c.itemOf(keys[i]).Expiration, c.itemOf(keys[j]).Expiration
+		if ei == 0 || ej == 0 {
+			return ej == 0 && ei != 0
+		}
+		return ei < ej
+	})
+	return keys
+}
+
+// 设置近似LRU淘汰的采样数量，EvictOldest在开启读取时间记录时只检查遍历到的前n个未过期数据项，
+// 从中淘汰最久未被读取的一个，用精度换取性能。n <= 0 表示检查全部数据项(精确LRU)
+// 默认的map存储每次遍历的起点是随机的，但这不是均匀的随机采样，相邻的数据项往往一起被检查
+func (c *Cache) SetEvictionSampleSize(n int) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.evictionSample = n
+}
+
+// 返回优先级最低的数据项中最久未被读取的未过期数据项的key，设置了采样数量时只在遍历到的前n个数据项中选择
+// 调用方需要持有锁
+func (c *Cache) leastRecentlyUsedKey() (string, bool) {
+	var (
+		key     string
+		at      int64
+		prio    int
+		found   bool
+		sampled int
+	)
+	c.items.rangeItems(func(k string, v Item) bool {
+		if c.expired(v) {
+			return true
+		}
+		if t := c.accessTime(k); !found || v.Priority < prio || (v.Priority == prio && t < at) {
+			key, at, prio, found = k, t, v.Priority, true
+		}
+		sampled++
+		// 只检查存储遍历顺序中的前n个未过期数据项，采样是否随机取决于存储的遍历顺序
+		return c.evictionSample <= 0 || sampled < c.evictionSample
+	})
+	return key, found
+}
@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvictFraction(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for i := 0; i < 100; i++ {
+		c.Set(strconv.Itoa(i), i, time.Duration(i+1)*time.Minute)
+	}
+	if n := c.EvictFraction(math.NaN()); n != 0 {
+		t.Fatalf("EvictFraction(NaN) = %d, want 0", n)
+	}
+	if n := c.EvictFraction(0.5); n != 50 {
+		t.Fatalf("EvictFraction(0.5) = %d, want 50", n)
+	}
+	for i := 0; i < 100; i++ {
+		_, found := c.Get(strconv.Itoa(i))
+		if want := i >= 50; found != want {
+			t.Fatalf("key %d found = %v, want %v", i, found, want)
+		}
+	}
+}
+
+func TestEvictOldest(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var evicted []string
+	c.OnEvicted(func(k string, _ interface{}) { evicted = append(evicted, k) })
+
+	c.Set("late", 1, time.Hour)
+	c.Set("soon", 2, time.Minute)
+	c.Set("never", 3, NoExpiration)
+	if k, ok := c.EvictOldest(); !ok || k != "soon" {
+		t.Fatalf("EvictOldest without LRU = %q, %v, want the nearest expiration", k, ok)
+	}
+
+	c.SetTrackAccess(true)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	for _, k := range []string{"a", "late", "never", "b"} {
+		c.Get(k)
+		time.Sleep(time.Millisecond)
+	}
+	c.Get("a")
+	if k, ok := c.EvictOldest(); !ok || k != "late" {
+		t.Fatalf("EvictOldest with LRU = %q, %v, want the least recently read key", k, ok)
+	}
+	if len(evicted) != 2 || evicted[0] != "soon" || evicted[1] != "late" {
+		t.Fatalf("OnEvicted saw %v, want [soon late]", evicted)
+	}
+
+	c.Flush()
+	if _, ok := c.EvictOldest(); ok {
+		t.Fatal("EvictOldest on an empty cache reported an eviction")
+	}
+}
+
+func TestPopExpiringBatch(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("forever", 0, NoExpiration)
+	for i := 1; i <= 5; i++ {
+		c.Set(strconv.Itoa(i), i, time.Duration(i)*time.Minute)
+	}
+	got := c.PopExpiringBatch(3)
+	if len(got) != 3 || got["1"] != 1 || got["2"] != 2 || got["3"] != 3 {
+		t.Fatalf("PopExpiringBatch(3) = %v, want 1, 2 and 3", got)
+	}
+	for _, k := range []string{"1", "2", "3"} {
+		if _, found := c.Get(k); found {
+			t.Fatalf("popped key %s is still cached", k)
+		}
+	}
+	if c.Count() != 3 {
+		t.Fatalf("Count() = %d, want 3", c.Count())
+	}
+	if got := c.PopExpiringBatch(10); len(got) != 3 || got["forever"] != 0 {
+		t.Fatalf("PopExpiringBatch(10) = %v, want the remaining 3 items", got)
+	}
+	if got := c.PopExpiringBatch(-1); len(got) != 0 {
+		t.Fatalf("PopExpiringBatch(-1) = %v, want empty", got)
+	}
+}
+
+func TestEvictionSampleSize(t *testing.T) {
+	for trial := 0; trial < 20; trial++ {
+		c := NewCache(time.Minute, time.Hour)
+		c.SetTrackAccess(true)
+		c.SetEvictionSampleSize(100)
+		for i := 0; i < 20; i++ {
+			c.Set(strconv.Itoa(i), i, DefaultExpiration)
+		}
+		for i := 0; i < 20; i++ {
+			c.Get(strconv.Itoa(i))
+			time.Sleep(10 * time.Microsecond)
+		}
+		if k, ok := c.EvictOldest(); !ok || k != "0" {
+			t.Fatalf("trial %d: EvictOldest() = %q, %v, want the least recently used 0", trial, k, ok)
+		}
+	}
+
+	c := NewCache(time.Minute, time.Hour)
+	c.SetTrackAccess(true)
+	c.SetEvictionSampleSize(1)
+	for i := 0; i < 5; i++ {
+		c.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	if _, ok := c.EvictOldest(); !ok || c.Count() != 4 {
+		t.Fatalf("EvictOldest with sample size 1 evicted nothing, Count() = %d", c.Count())
+	}
+}
+
+func TestEvictionSampleFirstVisited(t *testing.T) {
+	c, now := newFakeClockCache(time.Unix(1000, 0))
+	c.newStore = func() store { return &sortedStore{} }
+	c.items = c.newStore()
+	c.SetTrackAccess(true)
+	c.SetEvictionSampleSize(2)
+	keys := []string{"a", "b", "c", "d", "e"}
+	for _, k := range keys {
+		c.Set(k, k, DefaultExpiration)
+	}
+	// 按a到e的顺序读取，a是全局最久未被读取的
+	for _, k := range keys {
+		c.Get(k)
+		atomic.AddInt64(now, int64(time.Second))
+	}
+	// sortedStore按key从大到小遍历，采样只检查e和d
+	if k, ok := c.EvictOldest(); !ok || k != "d" {
+		t.Fatalf("EvictOldest() = %q, %v, want d, the older of the 2 visited items", k, ok)
+	}
+}
+
+func TestEvictByPriority(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetWithPriority("important", 1, time.Second, 10)
+	for i := 0; i < 3; i++ {
+		c.SetWithPriority(strconv.Itoa(i), i, time.Hour, 0)
+	}
+	for i := 0; i < 3; i++ {
+		if k, _ := c.EvictOldest(); k == "important" {
+			t.Fatalf("high-priority item evicted while %d low-priority items remained", 3-i)
+		}
+	}
+	if _, found := c.Get("important"); !found {
+		t.Fatal("high-priority item did not survive")
+	}
+	if k, _ := c.EvictOldest(); k != "important" {
+		t.Fatalf("EvictOldest() = %q, want important once it is the only item", k)
+	}
+
+	// 优先级相同时按LRU淘汰
+	c.SetTrackAccess(true)
+	c.SetWithPriority("a", 1, DefaultExpiration, 5)
+	c.SetWithPriority("b", 2, DefaultExpiration, 5)
+	c.SetWithPriority("low", 3, DefaultExpiration, 1)
+	c.Get("b")
+	time.Sleep(time.Millisecond)
+	c.Get("a")
+	c.Get("low")
+	if k, _ := c.EvictOldest(); k != "low" {
+		t.Fatalf("EvictOldest() = %q, want low", k)
+	}
+	if k, _ := c.EvictOldest(); k != "b" {
+		t.Fatalf("EvictOldest() = %q, want the least recently used b", k)
+	}
+}
+
+func TestDeleteOlderThan(t *testing.T) {
+	c, now := newFakeClockCache(time.Now())
+	advance := func(d time.Duration) { atomic.AddInt64(now, int64(d)) }
+	c.Set("oldest", 1, NoExpiration)
+	advance(time.Minute)
+	c.Set("old", 2, time.Hour)
+	c.Set("expired", 3, time.Second)
+	advance(time.Minute)
+	c.Set("new", 4, NoExpiration)
+	advance(10 * time.Second)
+	// 续期不改变写入时间，续期过的旧数据项同样会被删除
+	c.GetRefresh("old", time.Hour)
+	c.TouchFunc(time.Hour, func(k string, _ interface{}) bool { return k == "oldest" })
+
+	if n := c.DeleteOlderThan(30 * time.Second); n != 2 {
+		t.Fatalf("DeleteOlderThan removed %d items, want 2", n)
+	}
+	for _, k := range []string{"oldest", "old"} {
+		if _, found := c.Get(k); found {
+			t.Fatalf("%s survived DeleteOlderThan", k)
+		}
+	}
+	if _, found := c.Get("new"); !found {
+		t.Fatal("item newer than the cutoff was deleted")
+	}
+	if n := c.DeleteOlderThan(time.Hour); n != 0 {
+		t.Fatalf("DeleteOlderThan(1h) removed %d items, want 0", n)
+	}
+}
+
+func TestGCDurationMetrics(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	if d := c.LastGCDuration(); d != 0 {
+		t.Fatalf("LastGCDuration() = %v before any sweep, want 0", d)
+	}
+	for i := 0; i < 10000; i++ {
+		c.Set(strconv.Itoa(i), i, time.Millisecond)
+	}
+	var logged bytes.Buffer
+	c.SetSlowGCLog(log.New(&logged, "", 0), 0)
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	last := c.LastGCDuration()
+	if last <= 0 {
+		t.Fatalf("LastGCDuration() = %v after sweeping 10000 items, want > 0", last)
+	}
+	if c.MaxGCDuration() < last {
+		t.Fatalf("MaxGCDuration() = %v, less than LastGCDuration() %v", c.MaxGCDuration(), last)
+	}
+	if !strings.Contains(logged.String(), "removed 10000 items") {
+		t.Fatalf("slow sweep log = %q", logged.String())
+	}
+
+	c.SetSlowGCLog(log.New(&logged, "", 0), time.Hour)
+	logged.Reset()
+	c.DeleteExpired()
+	if logged.Len() != 0 {
+		t.Fatalf("sweep under the threshold was logged: %q", logged.String())
+	}
+	if c.MaxGCDuration() < last {
+		t.Fatal("MaxGCDuration decreased after a shorter sweep")
+	}
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// 热点key及其被Get读取的次数
+type HotKey struct {
+	Key      string
+	Accesses int64
+}
+
+// 开启热点key统计，最多为n个key计数，n <= 0 表示关闭并清空统计
+// 计数的key超过n个时，所有计数减半并丢弃减到0的key，仍然超过时丢弃计数最少的key，
+// 所以统计的key始终不超过n个，统计结果偏向近期访问
+func (c *Cache) EnableHotKeyTracking(n int) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.hotMu.Lock()
+	defer c.hotMu.Unlock()
+	c.hotLimit = n
+	if n > 0 {
+		if c.hotCounts == nil {
+			c.hotCounts = map[string]int64{}
+			c.hotStart = c.now()
+		}
+	} else {
+		c.hotCounts = nil
+	}
+}
+
+// 设置热点key统计的时间窗口，每经过d清空一次计数，HotKeys只返回当前窗口内的访问次数
+// d <= 0 表示不按时间清空，默认不清空
+func (c *Cache) SetHotKeyWindow(d time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.hotMu.Lock()
+	defer c.hotMu.Unlock()
+	c.hotWindow = d
+	c.hotStart = c.now()
+}
+
+// 返回访问次数最多的n个key，按访问次数从多到少排序
+func (c *Cache) HotKeys(n int) []HotKey {
+	c.hotMu.Lock()
+	c.rollHotWindow()
+	hot := make([]HotKey, 0, len(c.hotCounts))
+	for k, v := range c.hotCounts {
+		hot = append(hot, HotKey{k, v})
+	}
+	c.hotMu.Unlock()
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Accesses != hot[j].Accesses {
+			return hot[i].Accesses > hot[j].Accesses
+		}
+		return hot[i].Key < hot[j].Key
+	})
+	if n >= 0 && n < len(hot) {
+		hot = hot[:n]
+	}
+	return hot
+}
+
+// 当前统计窗口结束时清空计数并开始新的窗口，调用方需要持有c.hotMu
+func (c *Cache) rollHotWindow() {
+	if c.hotWindow <= 0 || c.hotCounts == nil {
+		return
+	}
+	if now := c.now(); now-c.hotStart >= int64(c.hotWindow) {
+		c.hotCounts = map[string]int64{}
+		c.hotStart = now
+	}
+}
+
+// 记录一次key的读取，调用方需要持有c.mu的读锁或写锁
+func (c *Cache) countHotKey(k string) {
+	c.hotMu.Lock()
+	defer c.hotMu.Unlock()
+	c.rollHotWindow()
+	if _, found := c.hotCounts[k]; !found && len(c.hotCounts) >= c.hotLimit {
+		for key, v := range c.hotCounts {
+			if v /= 2; v == 0 {
+				delete(c.hotCounts, key)
+			} else {
+				c.hotCounts[key] = v
+			}
+		}
+		if len(c.hotCounts) >= c.hotLimit {
+			c.evictColdestKey()
+		}
+	}
+	c.hotCounts[k]++
+}
+
+// 丢弃计数最少的key，计数相同时丢弃字典序最大的key，调用方需要持有c.hotMu
+func (c *Cache) evictColdestKey() {
+	var coldest string
+	min := int64(-1)
+	for k, v := range c.hotCounts {
+		if min < 0 || v < min || (v == min && k > coldest) {
+			coldest, min = k, v
+		}
+	}
+	delete(c.hotCounts, coldest)
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHotKeys(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.EnableHotKeyTracking(10)
+	for i := 0; i < 50; i++ {
+		c.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	c.Set("hot1", 1, DefaultExpiration)
+	c.Set("hot2", 2, DefaultExpiration)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				c.Get("hot1")
+				c.Get("hot2")
+				c.Get(strconv.Itoa(i % 50)) // 大量只读取几次的key
+			}
+		}()
+	}
+	wg.Wait()
+	hot := c.HotKeys(2)
+	if len(hot) != 2 {
+		t.Fatalf("HotKeys(2) = %v, want 2 entries", hot)
+	}
+	for _, h := range hot {
+		if h.Key != "hot1" && h.Key != "hot2" {
+			t.Fatalf("HotKeys(2) = %v, want hot1 and hot2", hot)
+		}
+	}
+	if len(c.hotCounts) > 10 {
+		t.Fatalf("tracking %d keys, want at most 10", len(c.hotCounts))
+	}
+	c.EnableHotKeyTracking(0)
+	if hot := c.HotKeys(5); len(hot) != 0 {
+		t.Fatalf("HotKeys after disabling = %v, want empty", hot)
+	}
+}
+
+func TestHotKeysBounded(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.EnableHotKeyTracking(3)
+	// 每个key都被读取多次，计数减半后仍不为0
+	for i := 0; i < 20; i++ {
+		k := strconv.Itoa(i)
+		c.Set(k, i, DefaultExpiration)
+		for j := 0; j < 8; j++ {
+			c.Get(k)
+		}
+		if len(c.hotCounts) > 3 {
+			t.Fatalf("tracking %d keys after %d distinct keys, want at most 3", len(c.hotCounts), i+1)
+		}
+	}
+	if hot := c.HotKeys(-1); len(hot) != 3 || hot[0].Key != "19" {
+		t.Fatalf("HotKeys = %v, want 3 entries led by the latest key", hot)
+	}
+}
+
+func TestHotKeyWindow(t *testing.T) {
+	c, now := newFakeClockCache(time.Now())
+	c.EnableHotKeyTracking(10)
+	c.SetHotKeyWindow(time.Minute)
+	c.Set("old", 1, DefaultExpiration)
+	c.Set("new", 2, DefaultExpiration)
+	for i := 0; i < 5; i++ {
+		c.Get("old")
+	}
+	atomic.AddInt64(now, int64(time.Minute))
+	if hot := c.HotKeys(-1); len(hot) != 0 {
+		t.Fatalf("HotKeys after the window = %v, want empty", hot)
+	}
+	c.Get("new")
+	if hot := c.HotKeys(-1); len(hot) != 1 || hot[0] != (HotKey{"new", 1}) {
+		t.Fatalf("HotKeys in the new window = %v, want only new", hot)
+	}
+	c.SetHotKeyWindow(0)
+	atomic.AddInt64(now, int64(time.Hour))
+	if hot := c.HotKeys(-1); len(hot) != 1 {
+		t.Fatalf("HotKeys without a window = %v, want the counts kept", hot)
+	}
+}
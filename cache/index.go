@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"sort"
+)
+
+// 按值的某个字段建立的二级索引
+type index struct {
+	extract func(v interface{}) (string, bool)
+	values  map[string]map[string]struct{} // 索引值到key集合的映射
+	keys    map[string]string              // key到索引值的映射，用于更新和删除
+}
+
+// 添加一个名为name的二级索引，extractor从值中提取索引值，返回false表示该值不参与索引
+// 索引会立即为已有的数据项建立，并在之后写入、删除和过期清理时自动维护
+// 同名的索引会被替换，extractor在锁内执行，不能调用缓存的方法
+func (c *Cache) AddIndex(name string, extractor func(v interface{}) (string, bool)) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.indexes == nil {
+		c.indexes = map[string]*index{}
+	}
+	idx := &index{
+		extract: extractor,
+		values:  map[string]map[string]struct{}{},
+		keys:    map[string]string{},
+	}
+	c.items.rangeItems(func(k string, item Item) bool {
+		idx.add(k, item.Object)
+		return true
+	})
+	c.indexes[name] = idx
+}
+
+// 返回索引name中索引值为indexValue的所有未过期数据项的值，按key的字典序排列
+// 索引不存在时返回nil
+func (c *Cache) GetByIndex(name, indexValue string) []interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	idx, found := c.indexes[name]
+	if !found {
+		return nil
+	}
+	keys := make([]string, 0, len(idx.values[indexValue]))
+	for k := range idx.values[indexValue] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		if v, found := c.get(k); found {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// 将key k的值v加入索引
+func (idx *index) add(k string, v interface{}) {
+	iv, ok := idx.extract(v)
+	if !ok {
+		return
+	}
+	ks, found := idx.values[iv]
+	if !found {
+		ks = map[string]struct{}{}
+		idx.values[iv] = ks
+	}
+	ks[k] = struct{}{}
+	idx.keys[k] = iv
+}
+
+// 将key k从索引中移除
+func (idx *index) remove(k string) {
+	iv, found := idx.keys[k]
+	if !found {
+		return
+	}
+	delete(idx.keys, k)
+	delete(idx.values[iv], k)
+	if len(idx.values[iv]) == 0 {
+		delete(idx.values, iv)
+	}
+}
+
+// 写入数据项后更新所有索引，调用方需要持有锁
+func (c *Cache) indexSet(k string, v interface{}) {
+	for _, idx := range c.indexes {
+		idx.remove(k)
+		idx.add(k, v)
+	}
+}
+
+// 删除数据项后更新所有索引，调用方需要持有锁
+func (c *Cache) indexDelete(k string) {
+	for _, idx := range c.indexes {
+		idx.remove(k)
+	}
+}
+
+// 整体替换数据项后重建所有索引，调用方需要持有锁
+func (c *Cache) rebuildIndexes() {
+	for _, idx := range c.indexes {
+		idx.values = map[string]map[string]struct{}{}
+		idx.keys = map[string]string{}
+		c.items.rangeItems(func(k string, item Item) bool {
+			idx.add(k, item.Object)
+			return true
+		})
+	}
+}
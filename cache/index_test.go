@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type indexedUser struct {
+	Name string
+	Team string
+}
+
+func TestIndex(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.Set("1", indexedUser{"alice", "red"}, DefaultExpiration)
+	c.AddIndex("team", func(v interface{}) (string, bool) {
+		u, ok := v.(indexedUser)
+		return u.Team, ok
+	})
+	c.Set("2", indexedUser{"bob", "blue"}, DefaultExpiration)
+	c.Set("3", indexedUser{"carol", "red"}, DefaultExpiration)
+	c.Set("other", "not a user", DefaultExpiration)
+	want := []interface{}{indexedUser{"alice", "red"}, indexedUser{"carol", "red"}}
+	if got := c.GetByIndex("team", "red"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetByIndex(red) = %v, want %v", got, want)
+	}
+
+	c.Set("3", indexedUser{"carol", "blue"}, DefaultExpiration)
+	c.Delete("1")
+	if got := c.GetByIndex("team", "red"); len(got) != 0 {
+		t.Fatalf("GetByIndex(red) = %v after update and delete, want empty", got)
+	}
+	c.Set("4", indexedUser{"dave", "blue"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	if got := c.GetByIndex("team", "blue"); len(got) != 2 {
+		t.Fatalf("GetByIndex(blue) = %v, want bob and carol", got)
+	}
+	if idx := c.indexes["team"]; len(idx.keys) != 2 {
+		t.Fatalf("index tracks %d keys, want 2", len(idx.keys))
+	}
+	if got := c.GetByIndex("missing", "red"); got != nil {
+		t.Fatalf("GetByIndex on a missing index = %v, want nil", got)
+	}
+}
@@ -0,0 +1,56 @@
+package cache
+
+import "sort"
+
+// 缓存的快照迭代器，创建时记录当时所有未过期数据项的key，之后每次Next重新读取当前的值
+// 迭代过程中不持有锁，可以在迭代时读写缓存；迭代期间被删除或已过期的key会被跳过，
+// 迭代期间新写入的key不会出现。迭代器不能在多个协程中同时使用
+type Iter struct {
+	c     *Cache
+	keys  []string
+	pos   int
+	key   string
+	value interface{}
+}
+
+// 返回遍历当前所有未过期数据项的迭代器，key按字典序遍历，使用for it.Next() {...}循环读取
+func (c *Cache) Iterator() *Iter {
+	c.mu.RLock()
+	keys := make([]string, 0, c.items.len())
+	c.items.rangeItems(func(k string, v Item) bool {
+		if !c.expired(v) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	c.mu.RUnlock()
+	sort.Strings(keys)
+	return &Iter{c: c, keys: keys}
+}
+
+// 移动到下一个仍然存在且未过期的数据项，没有更多数据项时返回false
+func (it *Iter) Next() bool {
+	for it.pos < len(it.keys) {
+		k := it.keys[it.pos]
+		it.pos++
+		it.c.mu.RLock()
+		item, found := it.c.items.get(k)
+		it.c.mu.RUnlock()
+		if found && !it.c.expired(item) {
+			it.key, it.value = k, item.Object
+			return true
+		}
+	}
+	it.key, it.value = "", nil
+	return false
+}
+
+// 返回当前数据项的key
+func (it *Iter) Key() string {
+	return it.key
+}
+
+// 返回当前数据项的值，是调用Next时读取到的值
+func (it *Iter) Value() interface{} {
+	return it.value
+}
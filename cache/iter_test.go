@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIteratorToleratesMutation(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for i := 0; i < 100; i++ {
+		c.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	it := c.Iterator()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < 100; i += 2 {
+			c.Delete(strconv.Itoa(i))
+		}
+	}()
+	seen := 0
+	for it.Next() {
+		// 迭代时可以写入缓存
+		c.Set("new-"+it.Key(), it.Value(), DefaultExpiration)
+		if it.Value() != mustAtoi(t, it.Key()) {
+			t.Fatalf("key %s has value %v", it.Key(), it.Value())
+		}
+		seen++
+	}
+	wg.Wait()
+	if seen < 50 || seen > 100 {
+		t.Fatalf("iterated over %d items, want between 50 and 100", seen)
+	}
+
+	it = c.Iterator()
+	if !it.Next() {
+		t.Fatal("iterator over a non-empty cache is empty")
+	}
+	first := it.Key()
+	c.Flush()
+	c.Set(first, "after", DefaultExpiration)
+	if it.Next() {
+		t.Fatalf("iterator returned %s, which was deleted before Next", it.Key())
+	}
+	if it.Key() != "" || it.Value() != nil {
+		t.Fatal("exhausted iterator still holds an item")
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,46 @@
+package cache
+
+import "strings"
+
+const (
+	keySep    = ':'  // Key拼接各部分使用的分隔符
+	keyEscape = '\\' // 转义符，各部分中的分隔符和转义符本身都会被转义
+)
+
+// 把多个部分拼接成一个缓存key，各部分中的分隔符会被转义，
+// 所以Key("a:b", "c")和Key("a", "b:c")得到不同的key，可以用SplitKey还原
+// 例外是没有任何部分的Key()，它与Key("")一样返回空字符串，SplitKey还原的结果为[""]
+func Key(parts ...string) string {
+	var b strings.Builder
+	for i, p := range parts {
+		if i > 0 {
+			b.WriteByte(keySep)
+		}
+		for j := 0; j < len(p); j++ {
+			if p[j] == keySep || p[j] == keyEscape {
+				b.WriteByte(keyEscape)
+			}
+			b.WriteByte(p[j])
+		}
+	}
+	return b.String()
+}
+
+// 把Key生成的key拆分回各个部分
+func SplitKey(k string) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(k); i++ {
+		switch {
+		case k[i] == keyEscape && i+1 < len(k):
+			i++
+			b.WriteByte(k[i])
+		case k[i] == keySep:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(k[i])
+		}
+	}
+	return append(parts, b.String())
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyRoundTrip(t *testing.T) {
+	cases := [][]string{
+		{"user", "42"},
+		{"a:b", "c"},
+		{"a", "b:c"},
+		{`back\slash`, `trailing\`},
+		{"", ":", ""},
+		{"single"},
+	}
+	seen := map[string][]string{}
+	for _, parts := range cases {
+		k := Key(parts...)
+		if got := SplitKey(k); !reflect.DeepEqual(got, parts) {
+			t.Fatalf("SplitKey(Key(%q)) = %q", parts, got)
+		}
+		if prev, dup := seen[k]; dup {
+			t.Fatalf("Key(%q) and Key(%q) collide as %q", prev, parts, k)
+		}
+		seen[k] = parts
+	}
+}
+
+func TestKeyNoParts(t *testing.T) {
+	if k := Key(); k != Key("") {
+		t.Fatalf("Key() = %q, want the same key as Key(\"\")", k)
+	}
+	if got := SplitKey(Key()); !reflect.DeepEqual(got, []string{""}) {
+		t.Fatalf("SplitKey(Key()) = %q, want [\"\"]", got)
+	}
+}
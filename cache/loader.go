@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 正在进行中的一次数据加载，相同key的并发请求共享同一次加载结果
+type call struct {
+	done chan struct{} // 加载结束后关闭
+	val  interface{}
+	err  error
+}
+
+// 设置同时执行的加载函数的最大数量，n <= 0 表示不限制
+func (c *Cache) SetMaxConcurrentLoads(n int) {
+	c.loadMu.Lock()
+	defer c.loadMu.Unlock()
+	if n <= 0 {
+		c.loadSem = nil
+		return
+	}
+	c.loadSem = make(chan struct{}, n)
+}
+
+// 获取数据项，如果数据项不存在或已过期，则调用f加载数据并以过期时间d写入缓存
+// 同一个key的并发调用只会执行一次f，f返回错误时不写入缓存
+func (c *Cache) GetOrSet(k string, d time.Duration, f func() (interface{}, error)) (interface{}, error) {
+	if v, found := c.Get(k); found {
+		return v, nil
+	}
+	v, _, err := c.load(context.Background(), k, func() (interface{}, time.Duration, error) {
+		v, err := f()
+		return v, d, err
+	})
+	return v, err
+}
+
+// 与GetOrSet相同，但等待其他调用的加载结果或等待并发加载名额时会在ctx被取消后返回ctx.Err()
+// 如果正在执行加载的调用在等待名额时被取消，共享这次加载的调用也会得到该错误
+func (c *Cache) GetOrSetCtx(ctx context.Context, k string, d time.Duration, f func() (interface{}, error)) (interface{}, error) {
+	if v, found := c.Get(k); found {
+		return v, nil
+	}
+	v, _, err := c.load(ctx, k, func() (interface{}, time.Duration, error) {
+		v, err := f()
+		return v, d, err
+	})
+	return v, err
+}
+
+// 与GetOrSet相同，额外返回本次调用是否真正执行了f
+// 并发调用时只有执行f的那一个调用方得到computed为true，共享加载结果或命中缓存的调用方得到false
+func (c *Cache) GetOrSetX(k string, d time.Duration, f func() (interface{}, error)) (value interface{}, computed bool, err error) {
+	if v, found := c.Get(k); found {
+		return v, false, nil
+	}
+	return c.load(context.Background(), k, func() (interface{}, time.Duration, error) {
+		v, err := f()
+		return v, d, err
+	})
+}
+
+// 与GetOrSet相同，但数据的过期时间由f和数据一起返回，适用于过期时间取决于数据内容的场景
+func (c *Cache) GetOrSetFunc(k string, f func() (interface{}, time.Duration, error)) (interface{}, error) {
+	if v, found := c.Get(k); found {
+		return v, nil
+	}
+	v, _, err := c.load(context.Background(), k, f)
+	return v, err
+}
+
+// 执行一次去重的加载，返回加载结果以及本次调用是否真正执行了f
+// 超过最大并发加载数时，调用方会阻塞等待，直到获得执行机会或ctx被取消
+// f发生panic时，等待同一次加载的调用方会得到错误，之后的调用会重新加载
+func (c *Cache) load(ctx context.Context, k string, f func() (interface{}, time.Duration, error)) (interface{}, bool, error) {
+	c.loadMu.Lock()
+	if c.calls == nil {
+		c.calls = map[string]*call{}
+	}
+	if cl, ok := c.calls[k]; ok {
+		c.loadMu.Unlock()
+		select {
+		case <-cl.done:
+			return cl.val, false, cl.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	cl := &call{done: make(chan struct{}), err: fmt.Errorf("Loader for %s panicked", k)}
+	c.calls[k] = cl
+	sem := c.loadSem
+	c.loadMu.Unlock()
+	defer func() {
+		c.loadMu.Lock()
+		delete(c.calls, k)
+		c.loadMu.Unlock()
+		close(cl.done)
+	}()
+
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			cl.err = ctx.Err()
+			return nil, false, cl.err
+		}
+		defer func() { <-sem }()
+	}
+	v, d, err := f()
+	if err == nil {
+		c.Set(k, v, d)
+	}
+	cl.val, cl.err = v, err
+	return v, true, err
+}
+
+// 加载函数报告数据不存在时load返回的错误，共享同一次加载的GetOrSet等调用会得到ErrKeyNotFound
+var errNegative = fmt.Errorf("%w: loader reported not found", ErrKeyNotFound)
+
+// 带否定缓存的GetOrSet：f返回的found为false时，以过期时间negTTL记录该key不存在，
+// 期间的调用直接返回found为false而不再调用f；f找到数据时以过期时间posTTL写入缓存
+// 否定记录不占用数据项，不会被Get看到，也不会随Save保存；之后写入该key或清空缓存时否定记录被清除
+// 同一个key的并发调用只会执行一次f，f返回错误时不写入缓存也不记录否定结果
+func (c *Cache) GetOrSetNeg(k string, posTTL, negTTL time.Duration, f func() (interface{}, bool, error)) (interface{}, bool, error) {
+	if v, found := c.Get(k); found {
+		return v, true, nil
+	}
+	if c.negativeHit(k) {
+		return nil, false, nil
+	}
+	v, _, err := c.load(context.Background(), k, func() (interface{}, time.Duration, error) {
+		v, found, err := f()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !found {
+			c.setNegative(k, negTTL)
+			return nil, 0, errNegative
+		}
+		return v, posTTL, nil
+	})
+	if err == errNegative {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// 判断key是否有未过期的否定记录
+func (c *Cache) negativeHit(k string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	exp, found := c.negative[k]
+	return found && c.now() <= exp
+}
+
+// 以过期时间d记录key不存在，d <= 0 时不记录
+func (c *Cache) setNegative(k string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if c.negative == nil {
+		c.negative = map[string]int64{}
+	}
+	c.negative[k] = c.now() + int64(d)
+}
+
+// 创建一个带默认加载函数的缓存系统，GetLoad在数据项不存在时使用loader加载数据
+// loader返回数据以及该数据的过期时间
+func NewLoadingCache(defaultExpiration, gcInterval time.Duration, loader func(key string) (interface{}, time.Duration, error)) *Cache {
+	c := NewCache(defaultExpiration, gcInterval)
+	c.loader = loader
+	return c
+}
+
+// 获取数据项，如果数据项不存在或已过期，则使用构造时设置的loader加载
+// 同一个key的并发调用只会执行一次loader，loader返回错误时不写入缓存
+func (c *Cache) GetLoad(k string) (interface{}, error) {
+	if v, found := c.Get(k); found {
+		return v, nil
+	}
+	if c.loader == nil {
+		return nil, fmt.Errorf("No loader configured for the cache")
+	}
+	v, _, err := c.load(context.Background(), k, func() (interface{}, time.Duration, error) {
+		return c.loader(k)
+	})
+	return v, err
+}
+
+// 为函数fn加上缓存，返回的函数先按keyFn(key)查找缓存，未命中时调用fn并以过期时间d写入缓存
+// 同一个key的并发未命中只会调用一次fn，fn返回错误时不写入缓存
+func Cached[K comparable, V any](c *Cache, keyFn func(K) string, fn func(K) (V, error), d time.Duration) func(K) (V, error) {
+	return func(key K) (V, error) {
+		var zero V
+		k := keyFn(key)
+		v, err := c.GetOrSet(k, d, func() (interface{}, error) {
+			v, err := fn(key)
+			return v, err
+		})
+		if err != nil {
+			return zero, err
+		}
+		if v == nil {
+			return zero, nil
+		}
+		r, ok := v.(V)
+		if !ok {
+			return zero, fmt.Errorf("The value for %s is not of type %T", k, zero)
+		}
+		return r, nil
+	}
+}
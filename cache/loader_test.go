@@ -0,0 +1,311 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentLoads(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetMaxConcurrentLoads(3)
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.GetOrSet(strconv.Itoa(i), 0, func() (interface{}, error) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return i, nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	if peak > 3 {
+		t.Fatalf("%d loaders ran at once, want at most 3", peak)
+	}
+	if c.Count() != 20 {
+		t.Fatalf("Count() = %d, want 20", c.Count())
+	}
+}
+
+func TestLoaderPanicReleasesKey(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetMaxConcurrentLoads(1)
+	func() {
+		defer func() { recover() }()
+		c.GetOrSet("k", 0, func() (interface{}, error) { panic("boom") })
+	}()
+	done := make(chan interface{})
+	go func() {
+		v, _ := c.GetOrSet("k", 0, func() (interface{}, error) { return 1, nil })
+		done <- v
+	}()
+	select {
+	case v := <-done:
+		if v != 1 {
+			t.Fatalf("GetOrSet after panic = %v, want 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetOrSet blocked after a loader panic")
+	}
+}
+
+func TestGetOrSetCtxCancelWhileWaitingForSlot(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetMaxConcurrentLoads(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go c.GetOrSet("slow", 0, func() (interface{}, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	called := false
+	_, err := c.GetOrSetCtx(ctx, "other", 0, func() (interface{}, error) {
+		called = true
+		return 2, nil
+	})
+	close(release)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if called {
+		t.Fatal("loader ran although no slot was acquired")
+	}
+	if _, found := c.Get("other"); found {
+		t.Fatal("cancelled load stored a value")
+	}
+}
+
+func TestLoadingCacheLoadsOncePerKey(t *testing.T) {
+	var calls sync.Map
+	c := NewLoadingCache(time.Minute, time.Hour, func(k string) (interface{}, time.Duration, error) {
+		n, _ := calls.LoadOrStore(k, new(int32))
+		atomic.AddInt32(n.(*int32), 1)
+		time.Sleep(5 * time.Millisecond)
+		if k == "bad" {
+			return nil, 0, errors.New("backend down")
+		}
+		return "v-" + k, time.Hour, nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := strconv.Itoa(i % 5)
+			if v, err := c.GetLoad(k); err != nil || v != "v-"+k {
+				t.Errorf("GetLoad(%q) = %v, %v", k, v, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	calls.Range(func(k, n interface{}) bool {
+		if got := atomic.LoadInt32(n.(*int32)); got != 1 {
+			t.Errorf("loader ran %d times for %v, want 1", got, k)
+		}
+		return true
+	})
+
+	if _, err := c.GetLoad("bad"); err == nil {
+		t.Fatal("GetLoad did not return the loader error")
+	}
+	if _, found := c.Get("bad"); found {
+		t.Fatal("loader error was cached")
+	}
+	c.GetLoad("bad")
+	if n, _ := calls.Load("bad"); atomic.LoadInt32(n.(*int32)) != 2 {
+		t.Fatal("failed load was not retried")
+	}
+}
+
+func TestGetOrSetXComputedOnce(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var computed, calls int32
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, fresh, err := c.GetOrSetX("k", DefaultExpiration, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(10 * time.Millisecond)
+				return "v", nil
+			})
+			if err != nil || v != "v" {
+				t.Errorf("GetOrSetX = %v, %v", v, err)
+			}
+			if fresh {
+				atomic.AddInt32(&computed, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if calls != 1 || computed != 1 {
+		t.Fatalf("f ran %d times and %d callers saw computed, want 1 and 1", calls, computed)
+	}
+	if _, fresh, _ := c.GetOrSetX("k", DefaultExpiration, nil); fresh {
+		t.Fatal("GetOrSetX reported computed on a cache hit")
+	}
+}
+
+func TestGetOrSetFuncTTL(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	ttls := map[string]time.Duration{"short": time.Second, "long": time.Hour, "forever": NoExpiration}
+	for k, d := range ttls {
+		d := d
+		v, err := c.GetOrSetFunc(k, func() (interface{}, time.Duration, error) { return k, d, nil })
+		if err != nil || v != k {
+			t.Fatalf("GetOrSetFunc(%q) = %v, %v", k, v, err)
+		}
+	}
+	for k, d := range ttls {
+		item := c.itemOf(k)
+		if d == NoExpiration {
+			if item.Expiration != 0 {
+				t.Fatalf("%s expiration = %d, want none", k, item.Expiration)
+			}
+			continue
+		}
+		if left := time.Until(time.Unix(0, item.Expiration)); left <= d-time.Second/2 || left > d {
+			t.Fatalf("%s expires in %v, want about %v", k, left, d)
+		}
+	}
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.GetOrSetFunc("shared", func() (interface{}, time.Duration, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(5 * time.Millisecond)
+				return 1, time.Minute, nil
+			})
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("loader ran %d times, want 1", calls)
+	}
+}
+
+func TestCached(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	var calls sync.Map
+	square := Cached(c, func(n int) string { return "sq:" + strconv.Itoa(n) }, func(n int) (int, error) {
+		cnt, _ := calls.LoadOrStore(n, new(int32))
+		atomic.AddInt32(cnt.(*int32), 1)
+		time.Sleep(5 * time.Millisecond)
+		if n < 0 {
+			return 0, errors.New("negative input")
+		}
+		return n * n, nil
+	}, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := i % 3
+			if v, err := square(n); err != nil || v != n*n {
+				t.Errorf("square(%d) = %d, %v", n, v, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	square(2)
+	calls.Range(func(n, cnt interface{}) bool {
+		if got := atomic.LoadInt32(cnt.(*int32)); got != 1 {
+			t.Errorf("fn(%v) called %d times, want 1", n, got)
+		}
+		return true
+	})
+	if v, _ := c.Get("sq:2"); v != 4 {
+		t.Fatalf("cached sq:2 = %v, want 4", v)
+	}
+	if _, err := square(-1); err == nil {
+		t.Fatal("error from fn was not returned")
+	}
+	if _, found := c.Get("sq:-1"); found {
+		t.Fatal("failed call was cached")
+	}
+
+	c.Set("sq:5", "not an int", time.Hour)
+	if _, err := square(5); err == nil {
+		t.Fatal("Cached returned a value of the wrong type without error")
+	}
+}
+
+func TestGetOrSetNeg(t *testing.T) {
+	c, now := newFakeClockCache(time.Now())
+	advance := func(d time.Duration) { atomic.AddInt64(now, int64(d)) }
+	calls := 0
+	exists := false
+	f := func() (interface{}, bool, error) {
+		calls++
+		if !exists {
+			return nil, false, nil
+		}
+		return "v", true, nil
+	}
+	for i := 0; i < 3; i++ {
+		if v, found, err := c.GetOrSetNeg("k", time.Hour, 10*time.Second, f); err != nil || found || v != nil {
+			t.Fatalf("GetOrSetNeg = %v, %v, %v, want not found", v, found, err)
+		}
+		advance(3 * time.Second)
+	}
+	if calls != 1 {
+		t.Fatalf("f called %d times within the negative TTL, want 1", calls)
+	}
+	if _, found := c.Get("k"); found {
+		t.Fatal("negative result is visible to Get")
+	}
+
+	advance(2 * time.Second)
+	exists = true
+	if v, found, err := c.GetOrSetNeg("k", time.Hour, 10*time.Second, f); err != nil || !found || v != "v" {
+		t.Fatalf("GetOrSetNeg after the negative TTL = %v, %v, %v, want v", v, found, err)
+	}
+	if calls != 2 {
+		t.Fatalf("f called %d times, want 2 after the negative TTL lapsed", calls)
+	}
+	advance(30 * time.Minute)
+	if v, found, _ := c.GetOrSetNeg("k", time.Hour, 10*time.Second, f); !found || v != "v" || calls != 2 {
+		t.Fatalf("positive result not cached for posTTL: %v, %v, %d calls", v, found, calls)
+	}
+
+	exists = false
+	c.GetOrSetNeg("other", time.Hour, time.Minute, f)
+	c.Set("other", "set", DefaultExpiration)
+	if v, found, _ := c.GetOrSetNeg("other", time.Hour, time.Minute, f); !found || v != "set" {
+		t.Fatalf("Set did not clear the negative record: %v, %v", v, found)
+	}
+	if _, _, err := c.GetOrSetNeg("err", time.Hour, time.Minute, func() (interface{}, bool, error) {
+		return nil, false, errors.New("backend down")
+	}); err == nil {
+		t.Fatal("GetOrSetNeg did not return the error from f")
+	}
+}
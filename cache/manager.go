@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 管理多个命名空间的缓存，所有命名空间共享同一个过期数据项清理协程和默认配置
+type CacheManager struct {
+	defaultExpiration time.Duration
+	gcInterval        time.Duration
+	mu                sync.Mutex
+	caches            map[string]*Cache // 命名空间名称到缓存的映射
+	stopGC            chan bool
+	gcStopped         bool // 清理协程是否已经退出
+}
+
+// 创建一个缓存管理器
+func NewCacheManager(defaultExpiration, gcInterval time.Duration) *CacheManager {
+	m := &CacheManager{
+		defaultExpiration: defaultExpiration,
+		gcInterval:        gcInterval,
+		caches:            map[string]*Cache{},
+		stopGC:            make(chan bool, 1),
+	}
+	go m.gcLoop()
+	return m
+}
+
+// 周期性的清理所有命名空间中的过期数据项，并记录到各命名空间的Status中
+func (m *CacheManager) gcLoop() {
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.gcStopped = true
+		for _, c := range m.caches {
+			atomic.StoreInt32(&c.gcRunning, 0)
+		}
+	}()
+	ticker := time.NewTicker(m.gcInterval)
+	for {
+		select {
+		case <-ticker.C:
+			for _, c := range m.all() {
+				c.recordGC(c.deleteExpired())
+			}
+		case <-m.stopGC:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// 返回所有命名空间的缓存
+func (m *CacheManager) all() []*Cache {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	caches := make([]*Cache, 0, len(m.caches))
+	for _, c := range m.caches {
+		caches = append(caches, c)
+	}
+	return caches
+}
+
+// 返回指定名称的命名空间缓存，不存在时创建
+// 命名空间缓存由管理器负责清理，不需要也不应该调用它的StopGC；它的Status返回管理器清理协程的状态
+func (m *CacheManager) Namespace(name string) *Cache {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, found := m.caches[name]
+	if !found {
+		c = newCache(m.defaultExpiration, m.gcInterval)
+		atomic.StoreInt64(&c.curGCInterval, int64(m.gcInterval))
+		if !m.gcStopped {
+			atomic.StoreInt32(&c.gcRunning, 1)
+		}
+		m.caches[name] = c
+	}
+	return c
+}
+
+// 清空所有命名空间
+func (m *CacheManager) FlushAll() {
+	for _, c := range m.all() {
+		c.Flush()
+	}
+}
+
+// 返回所有命名空间的数据项数量之和
+func (m *CacheManager) TotalCount() int {
+	n := 0
+	for _, c := range m.all() {
+		n += c.Count()
+	}
+	return n
+}
+
+// 停止过期缓存清理，通知不会阻塞，即使清理协程已经退出也会立即返回
+func (m *CacheManager) StopGC() {
+	select {
+	case m.stopGC <- true:
+	default: // 已经有一个未被处理的停止通知
+	}
+}
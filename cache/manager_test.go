@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheManagerNamespaces(t *testing.T) {
+	m := NewCacheManager(time.Minute, time.Hour)
+	defer m.StopGC()
+	users := m.Namespace("users")
+	sessions := m.Namespace("sessions")
+	if m.Namespace("users") != users {
+		t.Fatal("Namespace returned a different cache for the same name")
+	}
+	users.Set("id", "alice", DefaultExpiration)
+	sessions.Set("id", "s-1", DefaultExpiration)
+	sessions.Set("other", "s-2", DefaultExpiration)
+	if v, _ := users.Get("id"); v != "alice" {
+		t.Fatalf("users id = %v, want alice", v)
+	}
+	if v, _ := sessions.Get("id"); v != "s-1" {
+		t.Fatalf("sessions id = %v, want s-1", v)
+	}
+	if _, found := users.Get("other"); found {
+		t.Fatal("key leaked across namespaces")
+	}
+	if n := m.TotalCount(); n != 3 {
+		t.Fatalf("TotalCount() = %d, want 3", n)
+	}
+	m.FlushAll()
+	if n := m.TotalCount(); n != 0 {
+		t.Fatalf("TotalCount() after FlushAll = %d, want 0", n)
+	}
+}
+
+func TestCacheManagerSharedGC(t *testing.T) {
+	m := NewCacheManager(time.Minute, 5*time.Millisecond)
+	defer m.StopGC()
+	a, b := m.Namespace("a"), m.Namespace("b")
+	a.Set("k", 1, time.Millisecond)
+	b.Set("k", 2, time.Millisecond)
+	waitFor(t, time.Second, func() bool { return a.Count() == 0 && b.Count() == 0 })
+}
+
+func TestCacheManagerNamespaceStatus(t *testing.T) {
+	m := NewCacheManager(time.Minute, 5*time.Millisecond)
+	c := m.Namespace("a")
+	c.Set("k", 1, time.Millisecond)
+	waitFor(t, time.Second, func() bool { return c.Count() == 0 && !c.Status().LastGCTime.IsZero() })
+	if st := c.Status(); !st.GCRunning || st.GCInterval != 5*time.Millisecond {
+		t.Fatalf("namespace Status() = %+v, want the manager's GC running every 5ms", st)
+	}
+	m.StopGC()
+	waitFor(t, time.Second, func() bool { return !c.Status().GCRunning })
+	if m.Namespace("b").Status().GCRunning {
+		t.Fatal("namespace created after StopGC reports GCRunning")
+	}
+}
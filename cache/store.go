@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"io"
+	"time"
+)
+
+// 数据项的存储接口，Cache通过它读写数据项，所有方法都由Cache在持有锁时调用
+type store interface {
+	get(k string) (Item, bool)
+	set(k string, item Item)
+	delete(k string)
+	len() int
+	// 遍历所有数据项，f返回false时停止遍历，f中可以删除当前遍历到的数据项
+	rangeItems(f func(k string, item Item) bool)
+}
+
+// 默认的数据项存储，直接使用map
+type mapStore map[string]Item
+
+func (m mapStore) get(k string) (Item, bool) {
+	item, found := m[k]
+	return item, found
+}
+
+func (m mapStore) set(k string, item Item) {
+	m[k] = item
+}
+
+func (m mapStore) delete(k string) {
+	delete(m, k)
+}
+
+func (m mapStore) len() int {
+	return len(m)
+}
+
+func (m mapStore) rangeItems(f func(k string, item Item) bool) {
+	for k, v := range m {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// 创建一个使用newStore创建数据项存储的缓存，newStore为nil时使用默认的mapStore
+// Flush、Compact等整体替换数据项的操作也会通过newStore创建新的存储
+func newCacheWithStore(defaultExpiration, gcInterval time.Duration, newStore func() store) *Cache {
+	c := newCache(defaultExpiration, gcInterval)
+	if newStore != nil {
+		c.newStore = newStore
+		c.items = newStore()
+	}
+	c.gcRunning = 1
+	go c.gcLoop()
+	return c
+}
+
+// 用items创建一个与当前存储同类型的新存储，调用方需要持有锁
+func (c *Cache) storeFrom(items map[string]Item) store {
+	if c.newStore == nil {
+		return mapStore(items)
+	}
+	s := c.newStore()
+	for k, v := range items {
+		s.set(k, v)
+	}
+	return s
+}
+
+// 返回指定key的数据项，不存在时返回零值，调用方需要持有锁
+func (c *Cache) itemOf(k string) Item {
+	item, _ := c.items.get(k)
+	return item
+}
+
+// 以map的形式返回存储中的全部数据项，默认存储直接返回底层的map，不做复制
+func itemsMap(s store) map[string]Item {
+	if m, ok := s.(mapStore); ok {
+		return m
+	}
+	items := make(map[string]Item, s.len())
+	s.rangeItems(func(k string, item Item) bool {
+		items[k] = item
+		return true
+	})
+	return items
+}
+
+// 用当前的数据项重建一个大小合适的新存储，释放旧存储占用的内存
+// Go的map在删除元素后不会缩小，大量删除之后可以调用Compact回收内存
+func (c *Cache) Compact() {
+	c.mu.Lock()
+	defer c.unlock()
+	items := make(map[string]Item, c.items.len())
+	c.items.rangeItems(func(k string, item Item) bool {
+		items[k] = item
+		return true
+	})
+	c.items = c.storeFrom(items)
+	c.resetCopyOnWrite()
+}
+
+// 设置Save是否使用写时复制，默认关闭
+// 开启后Save只在获取当前map的引用时短暂加锁，编码在锁外进行；
+// 保存期间的第一次写入会先复制出一个新的map再修改，保存的始终是开始时刻的一致快照
+func (c *Cache) SetCopyOnWriteSave(enabled bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.cowSave = enabled
+}
+
+// 使用写时复制保存数据项
+func (c *Cache) saveCopyOnWrite(w io.Writer) error {
+	c.mu.Lock()
+	items := itemsMap(c.items)
+	_, shared := c.items.(mapStore) // 其他存储的itemsMap已经是一份复制，不需要写时复制
+	if shared {
+		c.cowRefs++
+	}
+	epoch := c.cowEpoch
+	codec := c.getCodec()
+	c.unlock()
+
+	err := codec.Encode(w, items)
+
+	if shared {
+		c.mu.Lock()
+		if c.cowEpoch == epoch {
+			c.cowRefs--
+		}
+		c.unlock()
+	}
+	return err
+}
+
+// 修改数据项之前调用，如果当前map正被Save引用，先复制一份再修改，调用方需要持有写锁
+func (c *Cache) beforeWrite() {
+	if c.cowRefs == 0 {
+		return
+	}
+	m := itemsMap(c.items)
+	items := make(map[string]Item, len(m))
+	for k, v := range m {
+		items[k] = v
+	}
+	c.items = mapStore(items)
+	c.resetCopyOnWrite()
+}
+
+// 当前map被整体替换后，之前的Save不再引用它，调用方需要持有写锁
+func (c *Cache) resetCopyOnWrite() {
+	c.cowRefs = 0
+	c.cowEpoch++
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 按key有序保存数据项的存储，用于验证Cache不依赖mapStore的实现
+type sortedStore struct {
+	keys  []string
+	items []Item
+}
+
+func (s *sortedStore) find(k string) (int, bool) {
+	i := sort.SearchStrings(s.keys, k)
+	return i, i < len(s.keys) && s.keys[i] == k
+}
+
+func (s *sortedStore) get(k string) (Item, bool) {
+	if i, ok := s.find(k); ok {
+		return s.items[i], true
+	}
+	return Item{}, false
+}
+
+func (s *sortedStore) set(k string, item Item) {
+	i, ok := s.find(k)
+	if ok {
+		s.items[i] = item
+		return
+	}
+	s.keys = append(s.keys, "")
+	copy(s.keys[i+1:], s.keys[i:])
+	s.keys[i] = k
+	s.items = append(s.items, Item{})
+	copy(s.items[i+1:], s.items[i:])
+	s.items[i] = item
+}
+
+func (s *sortedStore) delete(k string) {
+	if i, ok := s.find(k); ok {
+		s.keys = append(s.keys[:i], s.keys[i+1:]...)
+		s.items = append(s.items[:i], s.items[i+1:]...)
+	}
+}
+
+func (s *sortedStore) len() int {
+	return len(s.keys)
+}
+
+// 从后向前遍历，f删除当前数据项时不影响尚未遍历到的数据项
+func (s *sortedStore) rangeItems(f func(k string, item Item) bool) {
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if i >= len(s.keys) {
+			continue
+		}
+		if !f(s.keys[i], s.items[i]) {
+			return
+		}
+	}
+}
+
+func TestAlternativeStore(t *testing.T) {
+	c := newCacheWithStore(time.Minute, time.Hour, func() store { return &sortedStore{} })
+	defer c.StopGC()
+	checkStore := func() {
+		t.Helper()
+		if _, ok := c.items.(*sortedStore); !ok {
+			t.Fatalf("store replaced by %T", c.items)
+		}
+	}
+
+	c.Set("b", 2, DefaultExpiration)
+	c.Set("a", 1, DefaultExpiration)
+	c.Set("gone", 0, time.Millisecond)
+	if err := c.Add("a", 10, DefaultExpiration); err == nil {
+		t.Fatal("Add overwrote an existing key")
+	}
+	if err := c.Replace("b", 20, DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := c.Get("b"); !found || v != 20 {
+		t.Fatalf("Get(b) = %v, %v, want 20, true", v, found)
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	if c.Count() != 2 {
+		t.Fatalf("Count() = %d, want 2", c.Count())
+	}
+	c.Delete("a")
+	if _, found := c.Get("a"); found {
+		t.Fatal("deleted key a is still cached")
+	}
+
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c.Flush()
+	checkStore()
+	if err := c.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("b"); v != 20 {
+		t.Fatalf("b after Load = %v, want 20", v)
+	}
+	c.Compact()
+	checkStore()
+	if items := c.Drain(); len(items) != 1 || items["b"].Object != 20 {
+		t.Fatalf("Drain() = %v, want only b", items)
+	}
+	checkStore()
+}
+
+func TestCompact(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	for i := 0; i < 100000; i++ {
+		c.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	for i := 10; i < 100000; i++ {
+		c.Delete(strconv.Itoa(i))
+	}
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	c.Compact()
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	if c.Count() != 10 {
+		t.Fatalf("Count() = %d after Compact, want 10", c.Count())
+	}
+	for i := 0; i < 10; i++ {
+		if v, _ := c.Get(strconv.Itoa(i)); v != i {
+			t.Fatalf("%d = %v after Compact", i, v)
+		}
+	}
+	// 尽力检查内存确实被释放，GC的统计可能受其他测试影响
+	if after.HeapInuse >= before.HeapInuse {
+		t.Logf("heap in use did not shrink: %d -> %d", before.HeapInuse, after.HeapInuse)
+	}
+}
+
+func TestCopyOnWriteSave(t *testing.T) {
+	c := NewCache(NoExpiration, time.Hour)
+	c.SetCopyOnWriteSave(true)
+	for i := 0; i < 10; i++ {
+		c.Set(strconv.Itoa(i), i, NoExpiration)
+	}
+	pr, pw := io.Pipe()
+	saved := make(chan error, 1)
+	go func() {
+		err := c.Save(pw)
+		pw.CloseWithError(err)
+		saved <- err
+	}()
+	// 读出第一个字节后，Save正阻塞在写入上
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(pr, first); err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan struct{})
+	go func() {
+		c.Set("0", "changed", NoExpiration)
+		c.Set("new", 1, NoExpiration)
+		c.Delete("9")
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("writes blocked while Save was in progress")
+	}
+	rest, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewCache(NoExpiration, time.Hour)
+	if err := d.Load(bytes.NewReader(append(first, rest...))); err != nil {
+		t.Fatal(err)
+	}
+	if d.Count() != 10 {
+		t.Fatalf("snapshot has %d items, want the 10 present when Save started", d.Count())
+	}
+	if v, _ := d.Get("0"); v != 0 {
+		t.Fatalf("snapshot 0 = %v, want 0", v)
+	}
+	if _, found := d.Get("9"); !found {
+		t.Fatal("snapshot lost a key deleted after Save started")
+	}
+	if v, _ := c.Get("0"); v != "changed" {
+		t.Fatalf("live 0 = %v, want changed", v)
+	}
+}
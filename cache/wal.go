@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 预写日志的记录类型
+const (
+	walSet byte = iota
+	walDelete
+)
+
+// 日志记录数超过该值且超过数据项数量的两倍时，将日志压缩为当前数据的快照
+const walCompactThreshold = 10000
+
+// 单条日志记录的最大字节数，超过时认为日志已损坏
+const walMaxRecordSize = 64 << 20
+
+// 预写日志中的一条记录
+type walRecord struct {
+	Op   byte
+	Key  string
+	Item Item
+}
+
+// 预写日志，以追加的方式记录每一次数据项的修改
+type wal struct {
+	file       string
+	f          *os.File
+	records    int   // 自上次压缩以来写入的记录数
+	err        error // 最近一次写入或压缩日志的错误
+	noRegister bool  // 为true时编码前不调用gob.Register，与SetGobAutoRegister保持一致
+}
+
+// 开启预写日志，之后每一次数据项的写入和删除都会追加到file中
+// 启动时应先调用ReplayWAL恢复数据，再开启日志；写日志失败不会影响缓存操作，错误可以通过WALErr获取
+func (c *Cache) EnableWAL(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if c.wal != nil {
+		c.wal.f.Close()
+	}
+	c.wal = &wal{file: file, f: f, noRegister: c.noGobRegister}
+	return nil
+}
+
+// 关闭预写日志
+func (c *Cache) DisableWAL() error {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.wal == nil {
+		return nil
+	}
+	err := c.wal.f.Close()
+	c.wal = nil
+	return err
+}
+
+// 返回最近一次写入或压缩日志时的错误，没有错误或未开启日志时返回nil
+func (c *Cache) WALErr() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.wal == nil {
+		return nil
+	}
+	return c.wal.err
+}
+
+// 重放预写日志，恢复日志中记录的数据项，已过期的数据项会被跳过
+// 日志末尾因崩溃而写入不完整的记录会被忽略，重放的修改不会再次写入当前开启的日志
+func (c *Cache) ReplayWAL(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	c.mu.Lock()
+	defer c.unlock()
+	w := c.wal
+	c.wal = nil
+	defer func() { c.wal = w }()
+	for {
+		rec, err := readWALRecord(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch rec.Op {
+		case walSet:
+			if c.expired(rec.Item) {
+				c.delete(rec.Key)
+			} else {
+				rec.Item.Expiration = c.clampExpiration(rec.Item.Expiration)
+				c.clearExpireCallback(rec.Key)
+				c.putItem(rec.Key, rec.Item)
+			}
+		case walDelete:
+			c.delete(rec.Key)
+		}
+	}
+}
+
+// 记录一次数据项的修改，必要时压缩日志，调用方需要持有锁
+func (c *Cache) logWAL(op byte, k string, item Item) {
+	if c.wal == nil {
+		return
+	}
+	if err := c.wal.append(walRecord{Op: op, Key: k, Item: item}); err != nil {
+		c.wal.err = err
+	}
+	if c.wal.records > walCompactThreshold && c.wal.records > 2*c.items.len() {
+		if err := c.wal.compact(itemsMap(c.items)); err != nil {
+			c.wal.err = err
+		}
+	}
+}
+
+// 数据项被整体替换为items时，将日志压缩为items的快照
+// 压缩失败时旧日志仍在使用，改为逐条追加删除和写入记录，保证重放的结果与替换后的数据一致
+func (w *wal) replace(old store, items map[string]Item) {
+	err := w.compact(items)
+	if err == nil {
+		return
+	}
+	w.err = err
+	old.rangeItems(func(k string, _ Item) bool {
+		if _, found := items[k]; !found {
+			if err := w.append(walRecord{Op: walDelete, Key: k}); err != nil {
+				w.err = err
+			}
+		}
+		return true
+	})
+	for k, v := range items {
+		if err := w.append(walRecord{Op: walSet, Key: k, Item: v}); err != nil {
+			w.err = err
+		}
+	}
+}
+
+// 追加一条记录
+func (w *wal) append(rec walRecord) error {
+	data, err := encodeWALRecord(rec, !w.noRegister)
+	if err != nil {
+		return err
+	}
+	if _, err = w.f.Write(data); err != nil {
+		return err
+	}
+	w.records++
+	return nil
+}
+
+// 用items的快照替换日志内容，先写入临时文件再重命名，保证日志始终完整
+func (w *wal) compact(items map[string]Item) error {
+	tmp := w.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for k, v := range items {
+		if v.Expired() {
+			continue
+		}
+		data, err := encodeWALRecord(walRecord{Op: walSet, Key: k, Item: v}, !w.noRegister)
+		if err != nil {
+			continue
+		}
+		if _, err = bw.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, w.file); err != nil {
+		return err
+	}
+	nf, err := os.OpenFile(w.file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	w.f.Close()
+	w.f = nf
+	w.records = 0
+	return nil
+}
+
+// 将记录编码为4字节长度前缀加gob数据，每条记录独立编码，便于追加写入
+// register为true时先注册值的类型
+func encodeWALRecord(rec walRecord, register bool) (data []byte, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("Error registering from types with Gob library")
+		}
+	}()
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	if register && rec.Item.Object != nil {
+		gob.Register(rec.Item.Object)
+	}
+	if err = gob.NewEncoder(&buf).Encode(&rec); err != nil {
+		return nil, err
+	}
+	data = buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	return data, nil
+}
+
+// 读取一条记录
+func readWALRecord(r io.Reader) (walRecord, error) {
+	var rec walRecord
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return rec, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > walMaxRecordSize {
+		return rec, fmt.Errorf("WAL record size %d exceeds limit %d", n, walMaxRecordSize)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return rec, err
+	}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec)
+	return rec, err
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWALReplayAfterCrash(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.wal")
+	c := NewCache(time.Minute, time.Hour)
+	if err := c.EnableWAL(file); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Set("a", 3, NoExpiration)
+	c.Delete("b")
+	c.Set("short", 4, time.Millisecond)
+	// 模拟崩溃时写了一半的记录
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 100, 1, 2})
+	f.Close()
+	time.Sleep(5 * time.Millisecond)
+
+	r := NewCache(time.Minute, time.Hour)
+	r.Set("b", "stale", NoExpiration)
+	r.SetWithCallback("a", "old", NoExpiration, func(string, interface{}) {})
+	r.AddIndex("byValue", func(v interface{}) (string, bool) {
+		n, ok := v.(int)
+		return strconv.Itoa(n), ok
+	})
+	atomic.StoreInt32(&r.dirty, 0)
+	if err := r.ReplayWAL(file); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := r.Get("a"); v != 3 {
+		t.Fatalf("a = %v, want 3", v)
+	}
+	if _, found := r.Get("b"); found {
+		t.Fatal("deleted key b was restored")
+	}
+	if _, found := r.Get("short"); found {
+		t.Fatal("expired key was restored")
+	}
+	if _, ok := r.expireCallbacks["a"]; ok {
+		t.Fatal("replay kept the expire callback of a replaced item")
+	}
+	if vs := r.GetByIndex("byValue", "3"); len(vs) != 1 || vs[0] != 3 {
+		t.Fatalf("GetByIndex after replay = %v, want [3]", vs)
+	}
+	if atomic.LoadInt32(&r.dirty) != 1 {
+		t.Fatal("replay did not mark the cache dirty")
+	}
+}
+
+func TestWALRecordTooLarge(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.wal")
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], walMaxRecordSize+1)
+	if err := os.WriteFile(file, size[:], 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(time.Minute, time.Hour)
+	if err := c.ReplayWAL(file); err == nil {
+		t.Fatal("ReplayWAL accepted an oversized record")
+	}
+}
+
+func TestWALErr(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.wal")
+	c := NewCache(time.Minute, time.Hour)
+	if err := c.EnableWAL(file); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", 1, NoExpiration)
+	if err := c.WALErr(); err != nil {
+		t.Fatalf("WALErr() = %v, want nil", err)
+	}
+	c.wal.f.Close()
+	if err := c.Set("b", 2, NoExpiration); err != nil {
+		t.Fatalf("Set failed because of the WAL: %v", err)
+	}
+	if c.WALErr() == nil {
+		t.Fatal("WALErr() = nil after a failed append")
+	}
+}
+
+func TestWALCompactFailure(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.wal")
+	c := NewCache(time.Minute, time.Hour)
+	if err := c.EnableWAL(file); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	// 临时文件的位置被目录占用，压缩无法创建临时文件
+	if err := os.Mkdir(file+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	c.Flush()
+	if c.WALErr() == nil {
+		t.Fatal("WALErr() = nil after a failed compaction")
+	}
+	c.Set("c", 3, NoExpiration)
+
+	r := NewCache(time.Minute, time.Hour)
+	if err := r.ReplayWAL(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := r.Get("a"); found {
+		t.Fatal("replay restored a key removed by Flush")
+	}
+	if v, _ := r.Get("c"); v != 3 || r.Count() != 1 {
+		t.Fatalf("replay after a failed compaction: c = %v, count = %d, want 3 and 1", v, r.Count())
+	}
+}
+
+// 只在该测试中使用，不会被注册到gob
+type walUnregistered struct{ N int }
+
+func TestWALRespectsGobAutoRegister(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour)
+	c.SetGobAutoRegister(false)
+	if err := c.EnableWAL(filepath.Join(t.TempDir(), "cache.wal")); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", walUnregistered{N: 1}, NoExpiration)
+	if c.WALErr() == nil {
+		t.Fatal("WAL registered a value type with gob auto-registration disabled")
+	}
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// 每隔interval检查一次文件的修改时间，文件发生变化时调用LoadFile重新加载
+// 合并策略与Load相同：只有缓存中不存在或已失效的数据项会被文件中的数据覆盖
+// 后台协程在StopGC时退出，加载失败时会在下一个周期重试；interval必须为正数，否则返回错误
+func (c *Cache) WatchFile(file string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("Watch interval must be positive, got %v", interval)
+	}
+	var last time.Time
+	if fi, err := os.Stat(file); err == nil {
+		last = fi.ModTime()
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fi, err := os.Stat(file)
+				if err != nil || fi.ModTime().Equal(last) {
+					continue
+				}
+				if c.LoadFile(file) == nil {
+					last = fi.ModTime()
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
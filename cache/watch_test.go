@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.gob")
+	src := NewCache(NoExpiration, time.Hour)
+	src.Set("a", 1, NoExpiration)
+	if err := src.SaveToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(NoExpiration, time.Hour)
+	defer c.StopGC()
+	if err := c.WatchFile(file, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	src.Set("b", 2, NoExpiration)
+	if err := src.SaveToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	// 确保修改时间发生变化，部分文件系统的时间精度较低
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(file, future, future); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool {
+		v, _ := c.Get("b")
+		return v == 2
+	})
+}
+
+func TestWatchFileRejectsBadInterval(t *testing.T) {
+	c := NewCache(NoExpiration, time.Hour)
+	defer c.StopGC()
+	for _, d := range []time.Duration{0, -time.Second} {
+		if err := c.WatchFile("unused", d); err == nil {
+			t.Fatalf("WatchFile accepted interval %v", d)
+		}
+	}
+}